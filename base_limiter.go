@@ -1,10 +1,14 @@
 package rlutils
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"path/filepath"
-	"strings"
+	"net/netip"
+	"regexp"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/2manymws/rl"
@@ -14,6 +18,9 @@ import (
 const (
 	RemoteAddrKey = "remote_addr"
 	HostKey       = "host"
+
+	// ボディを読む上限のデフォルト
+	defaultMaxBodyBytes = 64 << 10
 )
 
 type Options struct {
@@ -22,21 +29,89 @@ type Options struct {
 	IgnorePathContains   []string
 	IgnorePathPrefixes   []string
 	IgnorePathSuffixes   []string
+	IgnorePathPatterns   []*regexp.Regexp
+	RequestPathPatterns  []*regexp.Regexp
+	RequestPathTemplates []*RouteTemplate
+	PathRules            []*PathRule
+	ParameterRules       []*ParameterRule
+	MaxBodyBytes         int64
+	UserAgentRules       []*UserAgentRule
+	BotCatalog           *BotCatalog
+	CrawlerVerifier      *CrawlerVerifier
+	ImpostorRule         *UserAgentRule
 	TargetConditionFuncs []func(r *http.Request) bool
+	TrustedProxies       []netip.Prefix
+	ForwardedHeader      string
+	AllowCIDRs           []netip.Prefix
+	IPv4PrefixLen        int
+	IPv6PrefixLen        int
+	KeyFunc              KeyFunc
+	KeyNamespace         string
+	LimiterName          string
+	HeaderMode           HeaderMode
+	Counter              rl.Counter
+	newCounter           func() rl.Counter // Counterを指定しない場合にリミッターの作成時に呼ぶ
+	limiterOptions       []string          // 指定されたリミッター固有のオプション
+	acceptedOptions      []string          // リミッターが使う固有のオプション
+	limiterKind          string
+	ruleWindowLen        time.Duration // 固有のオプションで指定したルールのウィンドウの最大
+	errs                 []error
+}
+
+// リミッター固有のオプションが指定されたことを記録する
+func (args *Options) limiterOption(name string) {
+	args.limiterOptions = append(args.limiterOptions, name)
+}
+
+// 固有のオプションで指定したルールのウィンドウを記録する
+// カウンタはリミッターとルールのうち最も長いウィンドウに合わせて保持する
+func (args *Options) ruleWindow(windowLen time.Duration) {
+	args.ruleWindowLen = max(args.ruleWindowLen, windowLen)
+}
+
+// リミッターの種類を指定する
+// 使わない固有のオプションが渡された場合のエラーに使う
+func limiterKind(limiter string) Option {
+	return func(args *Options) {
+		args.limiterKind = limiter
+	}
+}
+
+// リミッターが使う固有のオプションを指定する
+// 各リミッターの作成時に指定し、使わない固有のオプションが渡された場合はエラーにする
+func acceptOptions(names ...string) Option {
+	return func(args *Options) {
+		args.acceptedOptions = names
+	}
 }
 
 type Option func(*Options)
 
 type BaseLimiter struct {
-	reqLimit             int `mapstructure:"req_limit"`
-	windowLen            time.Duration
-	targetExtensions     map[string]struct{}
-	targetMethods        map[string]struct{}
-	ignorePathContains   []string
-	ignorePathPrefixes   []string
-	ignorePathSuffixes   []string
+	settings             *atomic.Pointer[settings]
+	ignorePathPatterns   []*regexp.Regexp
+	requestPathPatterns  []*regexp.Regexp
+	requestPathTemplates []*RouteTemplate
+	pathRules            []*PathRule
+	parameterRules       []*ParameterRule
+	maxBodyBytes         int64
+	userAgentRules       []*UserAgentRule
+	botCatalog           *BotCatalog
+	crawlerVerifier      *CrawlerVerifier
+	impostorRule         *UserAgentRule
 	targetConditionFuncs []func(r *http.Request) bool
+	trustedProxies       []netip.Prefix
+	forwardedHeader      string
+	allowCIDRs           *prefixSet
+	ipv4PrefixLen        int
+	ipv6PrefixLen        int
+	keyBy                KeyFunc
+	keyNamespace         string
+	limiterName          string
+	headerMode           HeaderMode
 	onRequestLimit       func(*rl.Context, string) http.HandlerFunc
+	err                  error
+	stopCounter          func()
 	rl.Counter
 }
 
@@ -46,8 +121,6 @@ func NewBaseLimiter(
 	onRequestLimit func(*rl.Context, string) http.HandlerFunc,
 	setters ...Option,
 ) BaseLimiter {
-	ttl := windowLen * 2 // 最低2回分のウィンドウ分のカウンタを維持する
-
 	options := Options{}
 
 	for _, setter := range setters {
@@ -55,43 +128,99 @@ func NewBaseLimiter(
 			setter(&options)
 		}
 	}
+	// プロキシが書き込まない転送ヘッダーはクライアントが自由に送れるので、読むヘッダーを推測しない
+	if len(options.TrustedProxies) > 0 && options.ForwardedHeader == "" {
+		options.errs = append(options.errs, errors.New("forwarded header is required with trusted proxies"))
+	}
+
+	// 使わないオプションを黙って無視しない
+	rejected := map[string]struct{}{}
+	for _, name := range options.limiterOptions {
+		if _, ok := rejected[name]; ok || slices.Contains(options.acceptedOptions, name) {
+			continue
+		}
+		rejected[name] = struct{}{}
+		limiter := options.limiterKind
+		if limiter == "" {
+			limiter = "base limiter"
+		}
+		options.errs = append(options.errs, fmt.Errorf("%s does not apply to %s", name, limiter))
+	}
+
+	ttl := max(windowLen, options.ruleWindowLen) * 2 // 最低2回分のウィンドウ分のカウンタを維持する
+
+	botCatalog := options.BotCatalog
+	if botCatalog == nil {
+		botCatalog = DefaultBotCatalog()
+	}
+
+	maxBodyBytes := options.MaxBodyBytes
+	if maxBodyBytes == 0 {
+		maxBodyBytes = defaultMaxBodyBytes
+	}
+
+	// Counterで指定されたカウンタは他のリミッターと共有している可能性があるので、作成したカウンタだけを止める
+	c := options.Counter
+	stopCounter := func() {}
+	if c == nil && options.newCounter != nil {
+		c = options.newCounter()
+		if s, ok := c.(interface{ Stop() }); ok {
+			stopCounter = s.Stop
+		}
+	}
+	if c == nil {
+		c, stopCounter = newDefaultCounter(ttl)
+	}
+
+	s := &atomic.Pointer[settings]{}
+	s.Store(newSettings(Settings{
+		ReqLimit:           reqLimit,
+		WindowLen:          windowLen,
+		TargetExtensions:   setKeys(options.TargetExtensions),
+		TargetMethods:      setKeys(options.TargetMethods),
+		IgnorePathContains: options.IgnorePathContains,
+		IgnorePathPrefixes: options.IgnorePathPrefixes,
+		IgnorePathSuffixes: options.IgnorePathSuffixes,
+	}))
 
 	return BaseLimiter{
-		reqLimit:             reqLimit,
-		windowLen:            windowLen,
-		Counter:              counter.New(ttl),
-		targetExtensions:     options.TargetExtensions,
-		targetMethods:        options.TargetMethods,
+		settings:             s,
+		Counter:              c,
+		stopCounter:          stopCounter,
 		onRequestLimit:       onRequestLimit,
-		ignorePathContains:   options.IgnorePathContains,
-		ignorePathPrefixes:   options.IgnorePathPrefixes,
-		ignorePathSuffixes:   options.IgnorePathSuffixes,
+		ignorePathPatterns:   options.IgnorePathPatterns,
+		requestPathPatterns:  options.RequestPathPatterns,
+		requestPathTemplates: options.RequestPathTemplates,
+		pathRules:            options.PathRules,
+		parameterRules:       options.ParameterRules,
+		maxBodyBytes:         maxBodyBytes,
+		userAgentRules:       options.UserAgentRules,
+		botCatalog:           botCatalog,
+		crawlerVerifier:      options.CrawlerVerifier,
+		impostorRule:         options.ImpostorRule,
 		targetConditionFuncs: options.TargetConditionFuncs,
+		trustedProxies:       options.TrustedProxies,
+		forwardedHeader:      options.ForwardedHeader,
+		allowCIDRs:           newPrefixSet(options.AllowCIDRs),
+		ipv4PrefixLen:        options.IPv4PrefixLen,
+		ipv6PrefixLen:        options.IPv6PrefixLen,
+		keyBy:                options.KeyFunc,
+		keyNamespace:         options.KeyNamespace,
+		limiterName:          options.LimiterName,
+		headerMode:           options.HeaderMode,
+		err:                  errors.Join(options.errs...),
 	}
 }
 
 func TargetExtensions(targetExtensions []string) Option {
 	return func(args *Options) {
-		args.TargetExtensions = make(map[string]struct{}, len(targetExtensions))
-		if len(targetExtensions) > 0 {
-			for _, ext := range targetExtensions {
-				if len(ext) > 0 && ext[0] != '.' {
-					ext = "." + ext
-				}
-				args.TargetExtensions[strings.ToLower(ext)] = struct{}{}
-			}
-		}
+		args.TargetExtensions = extensionSet(targetExtensions)
 	}
 }
 
 func TargetMethods(targetMethods []string) Option {
 	return func(args *Options) {
-		args.TargetMethods = make(map[string]struct{}, len(targetMethods))
-		if len(targetMethods) > 0 {
-			for _, method := range targetMethods {
-				args.TargetMethods[strings.ToLower(method)] = struct{}{}
-			}
-		}
+		args.TargetMethods = methodSet(targetMethods)
 	}
 }
 
@@ -119,8 +248,237 @@ func IgnorePathSuffixes(ignorePathSuffixes []string) Option {
 	}
 }
 
+// 正規表現にマッチするパスを対象外にする
+// 正規表現はリミッターの作成時にコンパイルし、不正な場合はエラーになる
+func IgnorePathRegexps(patterns []string) Option {
+	return func(args *Options) {
+		p, err := compileRegexps(patterns)
+		if err != nil {
+			args.errs = append(args.errs, fmt.Errorf("invalid ignore path regexp: %w", err))
+		}
+		args.IgnorePathPatterns = append(args.IgnorePathPatterns, p...)
+	}
+}
+
+// globにマッチするパスを対象外にする
+// 例えば/assets/**/*.mapは/assets以下の全ての.mapファイルにマッチする
+func IgnorePathGlobs(patterns []string) Option {
+	return func(args *Options) {
+		p, err := compileGlobs(patterns)
+		if err != nil {
+			args.errs = append(args.errs, fmt.Errorf("invalid ignore path glob: %w", err))
+		}
+		args.IgnorePathPatterns = append(args.IgnorePathPatterns, p...)
+	}
+}
+
+// RequestPathLimiterで制限するパスを正規表現で指定する
+// マッチしたリクエストは正規表現ごとにまとめて数え、正規表現の文字列をキーに使う
+func RequestPathRegexps(patterns []string) Option {
+	return func(args *Options) {
+		args.limiterOption("RequestPathRegexps")
+		p, err := compileRegexps(patterns)
+		if err != nil {
+			args.errs = append(args.errs, fmt.Errorf("invalid request path regexp: %w", err))
+		}
+		args.RequestPathPatterns = append(args.RequestPathPatterns, p...)
+	}
+}
+
+// RequestPathLimiterで制限するパスをglobで指定する
+// マッチしたリクエストはglobごとにまとめて数え、globを変換した正規表現の文字列をキーに使う
+func RequestPathGlobs(patterns []string) Option {
+	return func(args *Options) {
+		args.limiterOption("RequestPathGlobs")
+		p, err := compileGlobs(patterns)
+		if err != nil {
+			args.errs = append(args.errs, fmt.Errorf("invalid request path glob: %w", err))
+		}
+		args.RequestPathPatterns = append(args.RequestPathPatterns, p...)
+	}
+}
+
+// RequestPathLimiterで制限するパスを/users/{id}/exportのようなルートテンプレートで指定する
+// modeがRouteKeyTemplateの場合はテンプレートごとに、RouteKeyValuesの場合はプレースホルダの値ごとに数える
+func RequestPathTemplates(templates []string, mode RouteKeyMode) Option {
+	return func(args *Options) {
+		args.limiterOption("RequestPathTemplates")
+		for _, template := range templates {
+			t, err := ParseRouteTemplate(template, mode)
+			if err != nil {
+				args.errs = append(args.errs, err)
+				continue
+			}
+			args.RequestPathTemplates = append(args.RequestPathTemplates, t)
+		}
+	}
+}
+
+// BodyParameterLimiterがパラメーターを探すためにボディを読む上限のバイト数を指定する
+// 指定しない場合は64KiBまで読む
+func MaxBodyBytes(n int64) Option {
+	return func(args *Options) {
+		args.limiterOption("MaxBodyBytes")
+		if n <= 0 {
+			args.errs = append(args.errs, fmt.Errorf("invalid max body bytes: %d", n))
+			return
+		}
+		args.MaxBodyBytes = n
+	}
+}
+
+// UserAgentLimiterでボットの分類を判別するカタログを指定する
+// 指定しない場合は組み込みのカタログが使われる
+func UseBotCatalog(c *BotCatalog) Option {
+	return func(args *Options) {
+		args.limiterOption("UseBotCatalog")
+		args.BotCatalog = c
+	}
+}
+
+// 信頼するプロキシのCIDRを指定する
+// 信頼するプロキシからのリクエストは、ForwardedHeaderで指定したヘッダーからクライアントのアドレスを求める
+// ForwardedHeaderの指定は必須
+func TrustedProxies(cidrs []string) Option {
+	return func(args *Options) {
+		for _, cidr := range cidrs {
+			p, err := parsePrefix(cidr)
+			if err != nil {
+				args.errs = append(args.errs, fmt.Errorf("invalid trusted proxy: %s: %w", cidr, err))
+				continue
+			}
+			args.TrustedProxies = append(args.TrustedProxies, p)
+		}
+	}
+}
+
+// 信頼するプロキシがクライアントのアドレスを書き込むヘッダーを指定する
+// Forwarded, X-Forwarded-For, X-Real-IPのいずれかを指定し、他の転送ヘッダーはクライアントが送ったものとして無視する
+// TrustedProxiesを指定する場合は必須
+func ForwardedHeader(name string) Option {
+	return func(args *Options) {
+		switch h := http.CanonicalHeaderKey(name); h {
+		case forwardedHeader, xForwardedForHeader, xRealIPHeader:
+			args.ForwardedHeader = h
+		default:
+			args.errs = append(args.errs, fmt.Errorf("invalid forwarded header: %q", name))
+		}
+	}
+}
+
+// 制限の対象外にするクライアントのCIDRを指定する
+// ヘルスチェックや社内のバッチ、提携先のネットワークなどからのリクエストを除外するために使う
+// クライアントのアドレスはTrustedProxiesを考慮して求める
+func AllowCIDRs(cidrs []string) Option {
+	return func(args *Options) {
+		for _, cidr := range cidrs {
+			p, err := parsePrefix(cidr)
+			if err != nil {
+				args.errs = append(args.errs, fmt.Errorf("invalid allow CIDR: %s: %w", cidr, err))
+				continue
+			}
+			args.AllowCIDRs = append(args.AllowCIDRs, p)
+		}
+	}
+}
+
+// リモートアドレスをキーにする際に、アドレスをネットワークプレフィックスで集約する
+// 例えば24と56を指定すると、IPv4は/24、IPv6は/56単位でカウントする
+// 0を指定した場合は集約しない
+func IPPrefixLength(ipv4PrefixLen, ipv6PrefixLen int) Option {
+	return func(args *Options) {
+		if ipv4PrefixLen < 0 || ipv4PrefixLen > 32 {
+			args.errs = append(args.errs, fmt.Errorf("invalid IPv4 prefix length: %d", ipv4PrefixLen))
+		}
+		if ipv6PrefixLen < 0 || ipv6PrefixLen > 128 {
+			args.errs = append(args.errs, fmt.Errorf("invalid IPv6 prefix length: %d", ipv6PrefixLen))
+		}
+		args.IPv4PrefixLen = ipv4PrefixLen
+		args.IPv6PrefixLen = ipv6PrefixLen
+	}
+}
+
+// カウンタのキーを生成するKeyFuncを指定する
+// 文字列で指定するキーより優先される
+func KeyBy(f KeyFunc) Option {
+	return func(args *Options) {
+		args.KeyFunc = f
+	}
+}
+
+// リミッターの名前を指定する
+// 同じ種類のリミッターを複数使う場合に区別するために使い、KeyNamespaceを指定しない場合はキーの名前空間にもなる
+func LimiterName(name string) Option {
+	return func(args *Options) {
+		args.LimiterName = name
+	}
+}
+
+// カウンタのキーの名前空間を指定する
+// 指定しない場合はリミッターの名前が使われ、カウンタを共有するリミッター同士でキーが衝突しない
+func KeyNamespace(namespace string) Option {
+	return func(args *Options) {
+		args.KeyNamespace = namespace
+	}
+}
+
+// リクエスト数を数えるカウンタを指定する
+// 指定しない場合はプロセス内のメモリで数えるカウンタが使われる
+func Counter(c rl.Counter) Option {
+	return func(args *Options) {
+		args.Counter = c
+		args.newCounter = nil
+	}
+}
+
+// リミッターの作成時にカウンタを作成するよう指定する
+// 設定の検証のようにオプションを適用するだけの場合に、カウンタの期限切れを削除するgoroutineを起動しない
+func lazyCounter(f func() rl.Counter) Option {
+	return func(args *Options) {
+		args.Counter = nil
+		args.newCounter = f
+	}
+}
+
+// プロセス内のメモリで数えるカウンタを作成し、期限切れのカウントを削除するgoroutineを止める関数と一緒に返す
+func newDefaultCounter(ttl time.Duration) (*counter.Counter, func()) {
+	if ttl <= 0 {
+		return counter.New(ttl, counter.DisableAutoDeleteExpired()), func() {}
+	}
+	c := counter.New(ttl, counter.DisableAutoDeleteExpired())
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ttl)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.DeleteExpired()
+			}
+		}
+	}()
+	return c, sync.OnceFunc(func() { close(done) })
+}
+
+// リミッターが作成したカウンタの期限切れを削除するgoroutineを止める
+// Counterで指定したカウンタは止めない。止めた後もカウンタは使えるが、期限切れのキーは削除されなくなる
+func (l *BaseLimiter) Stop() {
+	if l.stopCounter != nil {
+		l.stopCounter()
+	}
+}
+
+// オプションの解釈に失敗した場合のエラーを返す
+func (l *BaseLimiter) Err() error {
+	return l.err
+}
+
+// ResponseHeadersでヘッダーを付けるように指定した場合にtrueを返す
+// HeaderModeIETFの場合は、rlが付けたX-RateLimit-*をIETFのヘッダーに置き換える
 func (l *BaseLimiter) ShouldSetXRateLimitHeaders(r *rl.Context) bool {
-	return false
+	return l.headerMode == HeaderModeXRateLimit || l.headerMode == HeaderModeIETF
 }
 
 func (l *BaseLimiter) Name() string {
@@ -128,7 +486,32 @@ func (l *BaseLimiter) Name() string {
 }
 
 func (l *BaseLimiter) IsTargetRequest(r *http.Request) bool {
-	return l.isTargetExtensions(r) && l.isTargetMethod(r) && l.isTargetPath(r) && l.isTargetCondition(r)
+	return l.isTargetRequest(l.settings.Load(), r)
+}
+
+func (l *BaseLimiter) isTargetRequest(s *settings, r *http.Request) bool {
+	return s.isTargetExtensions(r) && s.isTargetMethod(r) && s.isTargetPath(r) && l.isTargetPathPattern(r) && !l.isAllowed(r) && l.isTargetCondition(r)
+}
+
+func (l *BaseLimiter) isTargetPathPattern(r *http.Request) bool {
+	for _, p := range l.ignorePathPatterns {
+		if p.MatchString(r.URL.Path) {
+			return false
+		}
+	}
+	return true
+}
+
+// クライアントのアドレスがAllowCIDRsに含まれるかを返す
+func (l *BaseLimiter) isAllowed(r *http.Request) bool {
+	if l.allowCIDRs.len() == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(l.remoteAddr(r))
+	if err != nil {
+		return false
+	}
+	return l.allowCIDRs.contains(addr)
 }
 
 func (l *BaseLimiter) isTargetCondition(r *http.Request) bool {
@@ -139,57 +522,49 @@ func (l *BaseLimiter) isTargetCondition(r *http.Request) bool {
 	}
 	return true
 }
+
 func (l *BaseLimiter) isTargetExtensions(r *http.Request) bool {
-	if len(l.targetExtensions) == 0 {
-		return true
-	}
-	extension := strings.ToLower(filepath.Ext(r.URL.Path))
-	_, ok := l.targetExtensions[extension]
-	return ok
+	return l.settings.Load().isTargetExtensions(r)
 }
 
 func (l *BaseLimiter) isTargetMethod(r *http.Request) bool {
-	if len(l.targetMethods) == 0 {
-		return true
-	}
-	_, ok := l.targetMethods[strings.ToLower(r.Method)]
-	return ok
+	return l.settings.Load().isTargetMethod(r)
 }
 
 func (l *BaseLimiter) isTargetPath(r *http.Request) bool {
-	for _, ignore := range []struct {
-		path []string
-		f    func(string, string) bool
-	}{
-		{l.ignorePathPrefixes, strings.HasPrefix},
-		{l.ignorePathSuffixes, strings.HasSuffix},
-		{l.ignorePathContains, strings.Contains},
-	} {
-		if len(ignore.path) > 0 {
-			for _, ipath := range ignore.path {
-				if ignore.f(r.URL.Path, ipath) {
-					return false
-				}
-			}
-		}
-	}
-
-	return true
+	return l.settings.Load().isTargetPath(r)
 }
-func validateKey(key string) error {
 
-	for _, k := range []string{RemoteAddrKey, HostKey} {
-		if k == key {
-			return nil
-		}
+// キーに名前空間を付与する
+func (l *BaseLimiter) namespacedKey(key string) string {
+	if l.keyNamespace == "" {
+		return key
 	}
-	return fmt.Errorf("invalid key: %s", key)
+	return l.keyNamespace + ":" + key
 }
 
-func fillKey(r *http.Request, key string) string {
-	if key == RemoteAddrKey {
-		remoteAddr := strings.Split(r.RemoteAddr, ":")[0]
-		return remoteAddr
+// リクエスト元のクライアントのアドレスを返す
+func (l *BaseLimiter) remoteAddr(r *http.Request) string {
+	return clientAddr(r, l.trustedProxies, l.forwardedHeader)
+}
+
+// リモートアドレスをカウンタのキーとして返す
+// IPPrefixLengthが指定されている場合はネットワークプレフィックスに集約する
+func (l *BaseLimiter) remoteAddrKey(r *http.Request) string {
+	addr := l.remoteAddr(r)
+	if l.ipv4PrefixLen == 0 && l.ipv6PrefixLen == 0 {
+		return addr
+	}
+	a, err := netip.ParseAddr(addr)
+	if err != nil {
+		return addr
+	}
+	bits := l.ipv6PrefixLen
+	if a.Is4() {
+		bits = l.ipv4PrefixLen
+	}
+	if bits == 0 {
+		return addr
 	}
-	return r.Host
+	return netip.PrefixFrom(a, bits).Masked().String()
 }
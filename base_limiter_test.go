@@ -1,8 +1,13 @@
 package rlutils
 
 import (
+	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/2manymws/rl"
+	"github.com/2manymws/rl/counter"
 )
 
 func TestBaseLimiter_isTargetExtensions(t *testing.T) {
@@ -143,3 +148,160 @@ func TestBaseLimiter_isTargetMethod(t *testing.T) {
 		})
 	}
 }
+
+func TestBaseLimiter_Counter(t *testing.T) {
+	mockCounter := new(MockCounter)
+	bl := NewBaseLimiter(0, 0, nil, Counter(mockCounter))
+	if bl.Counter != mockCounter {
+		t.Errorf("Counter = %v, want %v", bl.Counter, mockCounter)
+	}
+
+	bl = NewBaseLimiter(0, time.Minute, nil)
+	if _, ok := bl.Counter.(*counter.Counter); !ok {
+		t.Errorf("Counter = %T, want *counter.Counter", bl.Counter)
+	}
+}
+
+type stoppableCounter struct {
+	MockCounter
+	stopped int
+}
+
+func (c *stoppableCounter) Stop() {
+	c.stopped++
+}
+
+func TestBaseLimiter_Stop(t *testing.T) {
+	// 指定したカウンタは他のリミッターと共有している可能性があるので止めない
+	shared := &stoppableCounter{}
+	bl := NewBaseLimiter(0, time.Minute, nil, Counter(shared))
+	bl.Stop()
+	if shared.stopped != 0 {
+		t.Errorf("stopped = %d, want 0", shared.stopped)
+	}
+
+	// 後から指定したカウンタが使われる
+	bl = NewBaseLimiter(0, time.Minute, nil, Counter(shared), GCRA(1))
+	if _, ok := bl.Counter.(*GCRACounter); !ok {
+		t.Errorf("Counter = %T, want *GCRACounter", bl.Counter)
+	}
+	bl.Stop()
+	bl.Stop()
+	bl = NewBaseLimiter(0, time.Minute, nil, TokenBucket(0), Counter(shared))
+	if bl.Counter != shared {
+		t.Errorf("Counter = %v, want %v", bl.Counter, shared)
+	}
+
+	bl = NewBaseLimiter(0, time.Minute, nil)
+	bl.Stop()
+	bl.Stop()
+}
+
+func TestLimiterSpecificOptions(t *testing.T) {
+	// 使わない固有のオプションは黙って無視せずエラーにする
+	rules := []PathRule{{Name: "search", Match: PathMatchPrefix, Pattern: "/search", ReqLimit: 10, WindowLen: time.Minute}}
+	if err := NewIPLimiter(10, time.Minute, nil, PathRules(rules)).Err(); err == nil {
+		t.Error("expected error for PathRules on ip limiter")
+	}
+	if _, err := NewGetParameterLimiter(nil, 10, time.Minute, RemoteAddrKey, nil, MaxBodyBytes(1024)); err == nil {
+		t.Error("expected error for MaxBodyBytes on get parameter limiter")
+	}
+	if _, err := NewConcurrencyLimiter(1, RemoteAddrKey, nil, UseBotCatalog(DefaultBotCatalog())); err == nil {
+		t.Error("expected error for UseBotCatalog on concurrency limiter")
+	}
+	if bl := NewBaseLimiter(10, time.Minute, nil, UserAgentRules(nil)); bl.Err() == nil {
+		t.Error("expected error for UserAgentRules on base limiter")
+	}
+
+	l, err := NewRequestPathLimiter(nil, nil, nil, 10, time.Minute, RemoteAddrKey, nil, PathRules(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Stop()
+}
+
+func TestSharedCounter(t *testing.T) {
+	shared := counter.New(2 * time.Minute)
+	ipLimiter := NewIPLimiter(10, time.Minute, nil, Counter(shared))
+	if err := ipLimiter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	apiLimiter, err := NewLimiter("api_limiter", nil, RemoteAddrKeyFunc(), 10, time.Minute, nil, Counter(shared))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := rl.New(ipLimiter, apiLimiter)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	window := time.Now().UTC().Truncate(time.Minute)
+	for _, key := range []string{"ip_limiter:192.0.2.1", "api_limiter:192.0.2.1"} {
+		got, err := shared.Get(key, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != 1 {
+			t.Errorf("Get(%s) = %d, want 1", key, got)
+		}
+	}
+}
+
+func TestBaseLimiter_AllowCIDRs(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		want       bool
+	}{
+		{"Allowed IPv4", "10.1.2.3:1234", "", false},
+		{"Allowed single address", "192.0.2.10:1234", "", false},
+		{"Next to single address", "192.0.2.11:1234", "", true},
+		{"Allowed IPv6", "[2001:db8::1]:1234", "", false},
+		{"Allowed IPv4-mapped IPv6", "[::ffff:10.1.2.3]:1234", "", false},
+		{"Not allowed", "172.16.0.1:1234", "", true},
+		{"Allowed client behind trusted proxy", "172.31.0.1:1234", "10.0.0.1", false},
+		{"Client behind trusted proxy", "172.31.0.1:1234", "198.51.100.1", true},
+		{"Untrusted proxy", "198.51.100.2:1234", "10.0.0.1", true},
+	}
+	bl := NewBaseLimiter(10, time.Minute, nil,
+		AllowCIDRs([]string{"10.0.0.0/8", "192.0.2.10", "2001:db8::/32"}),
+		TrustedProxies([]string{"172.31.0.0/16"}),
+		ForwardedHeader("X-Forwarded-For"),
+	)
+	if err := bl.Err(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if got := bl.IsTargetRequest(req); got != tt.want {
+				t.Errorf("IsTargetRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// XFFを追記するロードバランサーの後ろで、クライアントがForwardedを送っても許可されない
+	bl = NewBaseLimiter(10, time.Minute, nil,
+		AllowCIDRs([]string{"192.0.2.0/24"}),
+		TrustedProxies([]string{"10.0.0.0/8"}),
+		ForwardedHeader("X-Forwarded-For"),
+	)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	req.Header.Set("Forwarded", "for=192.0.2.10")
+	if !bl.IsTargetRequest(req) {
+		t.Error("spoofed Forwarded header must not bypass the limit")
+	}
+
+	bl = NewBaseLimiter(10, time.Minute, nil, AllowCIDRs([]string{"10.0.0.0/33", "health-check"}))
+	if bl.Err() == nil {
+		t.Error("expected error for invalid CIDRs")
+	}
+}
@@ -0,0 +1,219 @@
+package rlutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/2manymws/rl"
+)
+
+type BodyParameterLimiter struct {
+	bodyParameters map[string]string
+	rules          []*ParameterRule
+	names          []string
+	subjectKey     KeyFunc
+	Limiter
+}
+
+// POSTなどのボディに含まれるパラメーターごとにリクエスト数を制限する
+// 制限単位はホスト名とパラメーター
+// application/x-www-form-urlencodedはフィールド名で、JSONはトップレベルのフィールド名またはJSON Pointerでパラメーターを指定する
+// ボディは先頭からMaxBodyBytesまでだけを読み、後続のハンドラーのために元に戻す
+func NewBodyParameterLimiter(
+	bodyParameters map[string]string,
+	reqLimit int,
+	windowLen time.Duration,
+	key string,
+	onRequestLimit func(*rl.Context, string) http.HandlerFunc,
+	setter ...Option,
+) (*BodyParameterLimiter, error) {
+	l := &BodyParameterLimiter{
+		bodyParameters: bodyParameters,
+	}
+	base, err := NewLimiter(
+		"body_parameter_limiter",
+		nil,
+		l.bodyParameterKey,
+		reqLimit,
+		windowLen,
+		onRequestLimit,
+		append([]Option{acceptOptions("ParameterRules", "MaxBodyBytes")}, setter...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	l.Limiter = *base
+	l.rules = append(slices.Clone(l.parameterRules), exactParameterRules(bodyParameters)...)
+	for _, rule := range l.rules {
+		for _, c := range rule.Conditions {
+			if !slices.Contains(l.names, c.Name) {
+				l.names = append(l.names, c.Name)
+			}
+		}
+	}
+	l.subjectKey = l.keyBy
+	if l.subjectKey == nil {
+		f, err := ParseKey(key)
+		if err != nil {
+			l.Stop()
+			return nil, err
+		}
+		l.subjectKey = f
+	}
+	return l, nil
+}
+
+func (l *BodyParameterLimiter) bodyParameterKey(r *http.Request) (string, bool) {
+	if len(l.rules) == 0 || r.Body == nil || r.Body == http.NoBody {
+		return "", false
+	}
+	q, ok := l.readBodyParameters(r)
+	if !ok {
+		return "", false
+	}
+	params, ok := matchParameterRules(l.rules, q)
+	if !ok {
+		return "", false
+	}
+	key, ok := l.subjectKey(r)
+	if !ok {
+		return "", false
+	}
+	return key + "/" + params, true
+}
+
+// ボディの先頭を読んでパラメーターを取り出す
+// 読んだ分はボディの先頭に戻すので、後続のハンドラーはボディ全体を読める
+func (l *BodyParameterLimiter) readBodyParameters(r *http.Request) (url.Values, bool) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, false
+	}
+	isForm := mediaType == "application/x-www-form-urlencoded"
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	if !isForm && !isJSON {
+		return nil, false
+	}
+
+	body := r.Body
+	prefix, err := io.ReadAll(io.LimitReader(body, l.maxBodyBytes+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(prefix), body), body}
+	if err != nil {
+		return nil, false
+	}
+	truncated := int64(len(prefix)) > l.maxBodyBytes
+	if truncated {
+		prefix = prefix[:l.maxBodyBytes]
+	}
+
+	if isForm {
+		if truncated {
+			// 途中で切れたフィールドは値が正しくないので使わない
+			if i := bytes.LastIndexByte(prefix, '&'); i >= 0 {
+				prefix = prefix[:i]
+			} else {
+				return nil, false
+			}
+		}
+		q, err := url.ParseQuery(string(prefix))
+		if err != nil {
+			return nil, false
+		}
+		return q, true
+	}
+	if truncated {
+		// 途中で切れたJSONは解釈できない
+		return nil, false
+	}
+	return jsonParameters(prefix, l.names)
+}
+
+// JSONからnamesで指定したパラメーターを取り出す
+// /で始まる名前はJSON Pointer、それ以外はトップレベルのフィールド名として扱う
+// 値が配列の場合は各要素を同じ名前の値として扱う
+func jsonParameters(b []byte, names []string) (url.Values, bool) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, false
+	}
+	q := url.Values{}
+	for _, name := range names {
+		pointer := name
+		if !strings.HasPrefix(pointer, "/") {
+			pointer = "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+		}
+		v, ok := jsonPointer(doc, pointer)
+		if !ok {
+			continue
+		}
+		if a, ok := v.([]any); ok {
+			for _, e := range a {
+				if s, ok := jsonScalar(e); ok {
+					q.Add(name, s)
+				}
+			}
+			continue
+		}
+		if s, ok := jsonScalar(v); ok {
+			q.Add(name, s)
+		}
+	}
+	return q, true
+}
+
+// RFC 6901のJSON Pointerで値を取り出す
+func jsonPointer(doc any, pointer string) (any, bool) {
+	if pointer == "" {
+		return doc, true
+	}
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+	v := doc
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = unescape.Replace(token)
+		switch t := v.(type) {
+		case map[string]any:
+			e, ok := t[token]
+			if !ok {
+				return nil, false
+			}
+			v = e
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(t) || strconv.Itoa(i) != token {
+				return nil, false
+			}
+			v = t[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func jsonScalar(v any) (string, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case json.Number:
+		return t.String(), true
+	case bool:
+		return strconv.FormatBool(t), true
+	case nil:
+		return "", true
+	default:
+		return "", false
+	}
+}
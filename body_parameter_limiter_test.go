@@ -0,0 +1,190 @@
+package rlutils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/2manymws/rl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyParameterLimiter(t *testing.T) {
+	cases := []struct {
+		name                string
+		bodyParameters      map[string]string
+		options             []Option
+		contentType         string
+		body                string
+		expectedToBeLimited bool
+		expectedKey         string
+	}{
+		{
+			name:                "Form parameter matches",
+			bodyParameters:      map[string]string{"token": "123456"},
+			contentType:         "application/x-www-form-urlencoded",
+			body:                "user=a&token=123456",
+			expectedToBeLimited: true,
+			expectedKey:         "body_parameter_limiter:example.com/token=123456",
+		},
+		{
+			name:                "Form parameter does not match",
+			bodyParameters:      map[string]string{"token": "123456"},
+			contentType:         "application/x-www-form-urlencoded; charset=utf-8",
+			body:                "token=abcdef",
+			expectedToBeLimited: false,
+		},
+		{
+			name:                "JSON top level field matches",
+			bodyParameters:      map[string]string{"token": "123456"},
+			contentType:         "application/json",
+			body:                `{"token": "123456"}`,
+			expectedToBeLimited: true,
+			expectedKey:         "body_parameter_limiter:example.com/token=123456",
+		},
+		{
+			name: "JSON pointer matches",
+			options: []Option{ParameterRules([]ParameterRule{{Conditions: []ParameterCondition{
+				{Name: "/user/id", Match: ParameterPresent},
+				{Name: "/items/1/sku", Match: ParameterRegexp, Value: "^X"},
+			}}})},
+			contentType:         "application/vnd.api+json",
+			body:                `{"user": {"id": 42}, "items": [{"sku": "A1"}, {"sku": "X9"}]}`,
+			expectedToBeLimited: true,
+			expectedKey:         "body_parameter_limiter:example.com//user/id=42&/items/1/sku=X9",
+		},
+		{
+			name: "JSON array values",
+			options: []Option{ParameterRules([]ParameterRule{{Conditions: []ParameterCondition{
+				{Name: "tags", Match: ParameterAnyOf, Values: []string{"spam"}},
+			}}})},
+			contentType:         "application/json",
+			body:                `{"tags": ["news", "spam"]}`,
+			expectedToBeLimited: true,
+			expectedKey:         "body_parameter_limiter:example.com/tags=spam",
+		},
+		{
+			name:                "JSON boolean",
+			bodyParameters:      map[string]string{"admin": "true"},
+			contentType:         "application/json",
+			body:                `{"admin": true}`,
+			expectedToBeLimited: true,
+			expectedKey:         "body_parameter_limiter:example.com/admin=true",
+		},
+		{
+			name:                "Invalid JSON",
+			bodyParameters:      map[string]string{"token": "123456"},
+			contentType:         "application/json",
+			body:                `{"token": "123456"`,
+			expectedToBeLimited: false,
+		},
+		{
+			name:                "Unsupported content type",
+			bodyParameters:      map[string]string{"token": "123456"},
+			contentType:         "text/plain",
+			body:                "token=123456",
+			expectedToBeLimited: false,
+		},
+		{
+			name:                "Form parameter within limit",
+			bodyParameters:      map[string]string{"token": "123456"},
+			options:             []Option{MaxBodyBytes(20)},
+			contentType:         "application/x-www-form-urlencoded",
+			body:                "token=123456&data=" + strings.Repeat("x", 100),
+			expectedToBeLimited: true,
+			expectedKey:         "body_parameter_limiter:example.com/token=123456",
+		},
+		{
+			name:                "Truncated form parameter is ignored",
+			bodyParameters:      map[string]string{"token": "123"},
+			options:             []Option{MaxBodyBytes(9)},
+			contentType:         "application/x-www-form-urlencoded",
+			body:                "token=123456",
+			expectedToBeLimited: false,
+		},
+		{
+			name:                "Form parameter beyond limit is ignored",
+			bodyParameters:      map[string]string{"token": "123456"},
+			options:             []Option{MaxBodyBytes(20)},
+			contentType:         "application/x-www-form-urlencoded",
+			body:                "data=" + strings.Repeat("x", 100) + "&token=123456",
+			expectedToBeLimited: false,
+		},
+		{
+			name:                "Truncated JSON is ignored",
+			bodyParameters:      map[string]string{"token": "123456"},
+			options:             []Option{MaxBodyBytes(10)},
+			contentType:         "application/json",
+			body:                `{"token": "123456"}`,
+			expectedToBeLimited: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter, err := NewBodyParameterLimiter(tc.bodyParameters, 5, time.Minute, "host", nil, tc.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			rule, err := limiter.Rule(req)
+			assert.NoError(t, err)
+			if tc.expectedToBeLimited {
+				assert.Equal(t, tc.expectedKey, rule.Key)
+				assert.Equal(t, 5, rule.ReqLimit)
+			} else {
+				assert.Equal(t, -1, rule.ReqLimit)
+			}
+
+			// ボディは元に戻っている
+			b, err := io.ReadAll(req.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.body, string(b))
+		})
+	}
+}
+
+func TestBodyParameterLimiterRestoresBody(t *testing.T) {
+	limiter, err := NewBodyParameterLimiter(map[string]string{"token": "123456"}, 1, time.Minute, "host", func(_ *rl.Context, _ string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	h := rl.New(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		got = r.PostForm.Get("token")
+	}))
+
+	for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("token=123456"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, want, rec.Code)
+	}
+	assert.Equal(t, "123456", got)
+
+	_, err = NewBodyParameterLimiter(nil, 1, time.Minute, "host", nil, MaxBodyBytes(0))
+	assert.Error(t, err)
+}
+
+func TestJSONPointer(t *testing.T) {
+	q, ok := jsonParameters([]byte(`{"a/b": {"c~d": [1, "x", null]}, "e": {"f": 1}}`), []string{"/a~1b/c~0d/0", "/a~1b/c~0d/2", "/a~1b/c~0d/01", "a/b", "e", "/missing"})
+	assert.True(t, ok)
+	assert.Equal(t, []string{"1"}, q["/a~1b/c~0d/0"])
+	assert.Equal(t, []string{""}, q["/a~1b/c~0d/2"])
+	assert.NotContains(t, q, "/a~1b/c~0d/01")
+	assert.NotContains(t, q, "a/b")
+	assert.NotContains(t, q, "e")
+	assert.NotContains(t, q, "/missing")
+}
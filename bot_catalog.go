@@ -0,0 +1,215 @@
+package rlutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BotFamily はボットの分類
+type BotFamily string
+
+const (
+	BotFamilySearchEngine    BotFamily = "search_engine"
+	BotFamilySEOCrawler      BotFamily = "seo_crawler"
+	BotFamilyAIScraper       BotFamily = "ai_scraper"
+	BotFamilyHTTPLibrary     BotFamily = "http_library"
+	BotFamilyHeadlessBrowser BotFamily = "headless_browser"
+	BotFamilyMonitoring      BotFamily = "monitoring"
+)
+
+// BotSignature はユーザーエージェントからボットを判別するためのパターン
+// Patternは大文字と小文字を区別しない正規表現
+// VerifyDomainsには、VerifyCrawlersで逆引きの結果を確認する公式のドメインを指定する
+type BotSignature struct {
+	Name          string    `yaml:"name"`
+	Family        BotFamily `yaml:"family"`
+	Pattern       string    `yaml:"pattern"`
+	VerifyDomains []string  `yaml:"verify_domains"`
+	re            *regexp.Regexp
+}
+
+// BotCatalog はユーザーエージェントをボットの分類に対応づけるカタログ
+// シグネチャは定義した順に判定し、最初にマッチしたものを使う
+type BotCatalog struct {
+	signatures []*BotSignature
+	families   map[BotFamily]struct{}
+}
+
+// 組み込みのシグネチャ
+// 他の分類のパターンに含まれるものがあるため、AIスクレイパーを先に判定する
+// GCEのVMは誰でもbc.googleusercontent.comの逆引きを持てるため、Googlebotにはgae.googleusercontent.comだけを含める
+var defaultBotSignatures = []BotSignature{
+	{Name: "GPTBot", Family: BotFamilyAIScraper, Pattern: `gptbot`},
+	{Name: "ChatGPT-User", Family: BotFamilyAIScraper, Pattern: `chatgpt-user`},
+	{Name: "OAI-SearchBot", Family: BotFamilyAIScraper, Pattern: `oai-searchbot`},
+	{Name: "ClaudeBot", Family: BotFamilyAIScraper, Pattern: `claudebot|claude-web|anthropic-ai`},
+	{Name: "CCBot", Family: BotFamilyAIScraper, Pattern: `ccbot`},
+	{Name: "Google-Extended", Family: BotFamilyAIScraper, Pattern: `google-extended`},
+	{Name: "Applebot-Extended", Family: BotFamilyAIScraper, Pattern: `applebot-extended`},
+	{Name: "PerplexityBot", Family: BotFamilyAIScraper, Pattern: `perplexitybot|perplexity-user`},
+	{Name: "Bytespider", Family: BotFamilyAIScraper, Pattern: `bytespider`},
+	{Name: "Amazonbot", Family: BotFamilyAIScraper, Pattern: `amazonbot`},
+	{Name: "Meta-ExternalAgent", Family: BotFamilyAIScraper, Pattern: `meta-externalagent|facebookbot`},
+	{Name: "cohere-ai", Family: BotFamilyAIScraper, Pattern: `cohere-ai`},
+	{Name: "Diffbot", Family: BotFamilyAIScraper, Pattern: `diffbot`},
+	{Name: "YouBot", Family: BotFamilyAIScraper, Pattern: `youbot`},
+
+	{Name: "Googlebot", Family: BotFamilySearchEngine, Pattern: `googlebot|googleother|google-inspectiontool`, VerifyDomains: []string{"googlebot.com", "google.com", "gae.googleusercontent.com"}},
+	{Name: "Bingbot", Family: BotFamilySearchEngine, Pattern: `bingbot|bingpreview`, VerifyDomains: []string{"search.msn.com"}},
+	{Name: "Yahoo! Slurp", Family: BotFamilySearchEngine, Pattern: `yahoo! slurp`, VerifyDomains: []string{"crawl.yahoo.net"}},
+	{Name: "DuckDuckBot", Family: BotFamilySearchEngine, Pattern: `duckduckbot`},
+	{Name: "Baiduspider", Family: BotFamilySearchEngine, Pattern: `baiduspider`, VerifyDomains: []string{"baidu.com", "baidu.jp"}},
+	{Name: "YandexBot", Family: BotFamilySearchEngine, Pattern: `yandex(bot|images|mobilebot)`, VerifyDomains: []string{"yandex.ru", "yandex.net", "yandex.com"}},
+	{Name: "Applebot", Family: BotFamilySearchEngine, Pattern: `applebot`, VerifyDomains: []string{"applebot.apple.com"}},
+	{Name: "Sogou", Family: BotFamilySearchEngine, Pattern: `sogou web spider`},
+	{Name: "Yeti", Family: BotFamilySearchEngine, Pattern: `\byeti/`, VerifyDomains: []string{"naver.com"}},
+	{Name: "SeznamBot", Family: BotFamilySearchEngine, Pattern: `seznambot`, VerifyDomains: []string{"seznam.cz"}},
+
+	{Name: "AhrefsBot", Family: BotFamilySEOCrawler, Pattern: `ahrefs(bot|siteaudit)`},
+	{Name: "SemrushBot", Family: BotFamilySEOCrawler, Pattern: `semrushbot`},
+	{Name: "MJ12bot", Family: BotFamilySEOCrawler, Pattern: `mj12bot`},
+	{Name: "DotBot", Family: BotFamilySEOCrawler, Pattern: `dotbot`},
+	{Name: "rogerbot", Family: BotFamilySEOCrawler, Pattern: `rogerbot`},
+	{Name: "BLEXBot", Family: BotFamilySEOCrawler, Pattern: `blexbot`},
+	{Name: "DataForSeoBot", Family: BotFamilySEOCrawler, Pattern: `dataforseobot`},
+	{Name: "serpstatbot", Family: BotFamilySEOCrawler, Pattern: `serpstatbot`},
+	{Name: "Screaming Frog", Family: BotFamilySEOCrawler, Pattern: `screaming frog`},
+
+	{Name: "UptimeRobot", Family: BotFamilyMonitoring, Pattern: `uptimerobot`},
+	{Name: "Pingdom", Family: BotFamilyMonitoring, Pattern: `pingdom`},
+	{Name: "StatusCake", Family: BotFamilyMonitoring, Pattern: `statuscake`},
+	{Name: "Datadog", Family: BotFamilyMonitoring, Pattern: `datadog(agent| synthetic)`},
+	{Name: "New Relic", Family: BotFamilyMonitoring, Pattern: `newrelicpinger|new relic synthetics`},
+	{Name: "Site24x7", Family: BotFamilyMonitoring, Pattern: `site24x7`},
+	{Name: "Better Stack", Family: BotFamilyMonitoring, Pattern: `better (uptime|stack)`},
+	{Name: "kube-probe", Family: BotFamilyMonitoring, Pattern: `^kube-probe/`},
+	{Name: "ELB-HealthChecker", Family: BotFamilyMonitoring, Pattern: `^elb-healthchecker/`},
+	{Name: "GoogleHC", Family: BotFamilyMonitoring, Pattern: `^googlehc/`},
+
+	{Name: "HeadlessChrome", Family: BotFamilyHeadlessBrowser, Pattern: `headlesschrome`},
+	{Name: "PhantomJS", Family: BotFamilyHeadlessBrowser, Pattern: `phantomjs`},
+	{Name: "Playwright", Family: BotFamilyHeadlessBrowser, Pattern: `playwright`},
+	{Name: "Selenium", Family: BotFamilyHeadlessBrowser, Pattern: `selenium`},
+
+	{Name: "curl", Family: BotFamilyHTTPLibrary, Pattern: `^curl/`},
+	{Name: "Wget", Family: BotFamilyHTTPLibrary, Pattern: `^wget/`},
+	{Name: "python-requests", Family: BotFamilyHTTPLibrary, Pattern: `python-requests/`},
+	{Name: "python-httpx", Family: BotFamilyHTTPLibrary, Pattern: `python-httpx/`},
+	{Name: "python-urllib", Family: BotFamilyHTTPLibrary, Pattern: `python-urllib/`},
+	{Name: "aiohttp", Family: BotFamilyHTTPLibrary, Pattern: `aiohttp/`},
+	{Name: "Scrapy", Family: BotFamilyHTTPLibrary, Pattern: `scrapy/`},
+	{Name: "Go-http-client", Family: BotFamilyHTTPLibrary, Pattern: `^go-http-client/`},
+	{Name: "Java", Family: BotFamilyHTTPLibrary, Pattern: `^java/|apache-httpclient/|^okhttp/`},
+	{Name: "Node.js", Family: BotFamilyHTTPLibrary, Pattern: `node-fetch/|^axios/|^undici`},
+	{Name: "libwww-perl", Family: BotFamilyHTTPLibrary, Pattern: `libwww-perl/`},
+	{Name: "Ruby", Family: BotFamilyHTTPLibrary, Pattern: `^ruby|^faraday`},
+	{Name: "Guzzle", Family: BotFamilyHTTPLibrary, Pattern: `guzzlehttp/`},
+}
+
+var defaultBotCatalog = func() *BotCatalog {
+	c, err := NewBotCatalog(defaultBotSignatures)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}()
+
+// 組み込みのシグネチャを返す
+// 独自のシグネチャを追加したカタログを作る場合に使う
+func DefaultBotSignatures() []BotSignature {
+	signatures := make([]BotSignature, len(defaultBotSignatures))
+	copy(signatures, defaultBotSignatures)
+	return signatures
+}
+
+// 組み込みのシグネチャのカタログを返す
+func DefaultBotCatalog() *BotCatalog {
+	return defaultBotCatalog
+}
+
+// シグネチャからカタログを作成する
+func NewBotCatalog(signatures []BotSignature) (*BotCatalog, error) {
+	c := &BotCatalog{families: map[BotFamily]struct{}{}}
+	var errs []error
+	for _, s := range signatures {
+		s := s
+		if s.Name == "" || s.Family == "" || s.Pattern == "" {
+			errs = append(errs, fmt.Errorf("bot signature %q: name, family and pattern are required", s.Name))
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + s.Pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("bot signature %s: %w", s.Name, err))
+			continue
+		}
+		s.re = re
+		c.signatures = append(c.signatures, &s)
+		c.families[s.Family] = struct{}{}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// YAMLまたはJSONのファイルからカタログを読み込む
+// extendsにdefaultを指定すると、ファイルのシグネチャを組み込みのシグネチャより先に判定する
+//
+//	extends: default
+//	signatures:
+//	  - {name: ExampleBot, family: ai_scraper, pattern: examplebot}
+func LoadBotCatalog(path string) (*BotCatalog, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f struct {
+		Extends    string         `yaml:"extends"`
+		Signatures []BotSignature `yaml:"signatures"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	switch f.Extends {
+	case "":
+	case "default":
+		f.Signatures = append(f.Signatures, defaultBotSignatures...)
+	default:
+		return nil, fmt.Errorf("invalid extends: %q", f.Extends)
+	}
+	return NewBotCatalog(f.Signatures)
+}
+
+// ユーザーエージェントにマッチするシグネチャを返す
+func (c *BotCatalog) Classify(ua string) (BotSignature, bool) {
+	if s := c.classify(ua); s != nil {
+		return *s, true
+	}
+	return BotSignature{}, false
+}
+
+func (c *BotCatalog) classify(ua string) *BotSignature {
+	if ua == "" {
+		return nil
+	}
+	for _, s := range c.signatures {
+		if s.re.MatchString(ua) {
+			return s
+		}
+	}
+	return nil
+}
+
+// カタログに分類が定義されているかを返す
+func (c *BotCatalog) HasFamily(f BotFamily) bool {
+	_, ok := c.families[f]
+	return ok
+}
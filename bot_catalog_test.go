@@ -0,0 +1,114 @@
+package rlutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultBotCatalog(t *testing.T) {
+	tests := []struct {
+		ua         string
+		wantName   string
+		wantFamily BotFamily
+	}{
+		{"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2; +https://openai.com/gptbot)", "GPTBot", BotFamilyAIScraper},
+		{"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; ClaudeBot/1.0; +claudebot@anthropic.com)", "ClaudeBot", BotFamilyAIScraper},
+		{"CCBot/2.0 (https://commoncrawl.org/faq/)", "CCBot", BotFamilyAIScraper},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "Googlebot", BotFamilySearchEngine},
+		{"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)", "Bingbot", BotFamilySearchEngine},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_5) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.1.1 Safari/605.1.15 (Applebot/0.1)", "Applebot", BotFamilySearchEngine},
+		{"Mozilla/5.0 (compatible; AhrefsBot/7.0; +http://ahrefs.com/robot/)", "AhrefsBot", BotFamilySEOCrawler},
+		{"Mozilla/5.0 (compatible; SemrushBot/7~bl; +http://www.semrush.com/bot.html)", "SemrushBot", BotFamilySEOCrawler},
+		{"curl/8.4.0", "curl", BotFamilyHTTPLibrary},
+		{"python-requests/2.31.0", "python-requests", BotFamilyHTTPLibrary},
+		{"Go-http-client/1.1", "Go-http-client", BotFamilyHTTPLibrary},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36", "HeadlessChrome", BotFamilyHeadlessBrowser},
+		{"Mozilla/5.0+(compatible; UptimeRobot/2.0; http://www.uptimerobot.com/)", "UptimeRobot", BotFamilyMonitoring},
+		{"kube-probe/1.29", "kube-probe", BotFamilyMonitoring},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", "", ""},
+		{"", "", ""},
+	}
+	c := DefaultBotCatalog()
+	for _, tt := range tests {
+		t.Run(tt.ua, func(t *testing.T) {
+			s, ok := c.Classify(tt.ua)
+			assert.Equal(t, tt.wantName != "", ok)
+			assert.Equal(t, tt.wantName, s.Name)
+			assert.Equal(t, tt.wantFamily, s.Family)
+		})
+	}
+}
+
+func TestLoadBotCatalog(t *testing.T) {
+	c, err := LoadBotCatalog("testdata/bot_catalog.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := c.Classify("example-scraper/1.0")
+	assert.True(t, ok)
+	assert.Equal(t, BotFamilyAIScraper, s.Family)
+	s, ok = c.Classify("curl/8.4.0")
+	assert.True(t, ok)
+	assert.Equal(t, BotFamilyHTTPLibrary, s.Family)
+	assert.True(t, c.HasFamily("internal"))
+	assert.False(t, DefaultBotCatalog().HasFamily("internal"))
+
+	dir := t.TempDir()
+	for _, content := range []string{
+		"extends: other\n",
+		"signatures:\n  - {name: a, family: b, pattern: '('}\n",
+		"signatures:\n  - {name: a, pattern: b}\n",
+		"unknown: true\n",
+	} {
+		path := filepath.Join(dir, "catalog.yml")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadBotCatalog(path)
+		assert.Error(t, err, content)
+	}
+}
+
+func TestUserAgentLimiterBotFamily(t *testing.T) {
+	catalog, err := LoadBotCatalog("testdata/bot_catalog.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := []UserAgentRule{
+		{Match: UserAgentFamily, Pattern: string(BotFamilyAIScraper), ReqLimit: 60, WindowLen: time.Minute},
+		{Match: UserAgentFamily, Pattern: "internal", ReqLimit: 1000, WindowLen: time.Minute},
+		{Name: "libraries", Match: UserAgentFamily, Pattern: string(BotFamilyHTTPLibrary), ReqLimit: 10, WindowLen: time.Minute, KeyByClientIP: true},
+	}
+	limiter := NewUserAgentLimiter(nil, 5, time.Minute, nil, UserAgentRules(rules), UseBotCatalog(catalog))
+	if err := limiter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		ua               string
+		expectedKey      string
+		expectedReqLimit int
+	}{
+		{"Mozilla/5.0 (compatible; GPTBot/1.2)", "user_agent_limiter:ai_scraper", 60},
+		{"example-scraper/1.0", "user_agent_limiter:ai_scraper", 60},
+		{"internal-monitor/2", "user_agent_limiter:internal", 1000},
+		{"curl/8.4.0", "user_agent_limiter:libraries+192.0.2.1", 10},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1)", "", -1},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("User-Agent", tc.ua)
+		rule, err := limiter.Rule(req)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expectedKey, rule.Key, tc.ua)
+		assert.Equal(t, tc.expectedReqLimit, rule.ReqLimit, tc.ua)
+	}
+
+	// カタログにない分類はエラーになる
+	assert.Error(t, NewUserAgentLimiter(nil, 5, time.Minute, nil, UserAgentRules(rules)).Err())
+}
@@ -0,0 +1,137 @@
+package rlutils
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/2manymws/rl"
+)
+
+// 設定に持たせるウィンドウの長さ
+// 同時に処理するリクエスト数はウィンドウを使わずに数えるので、長さに意味はない
+const concurrencyWindowLen = time.Second
+
+// ConcurrencyLimiter はキーごとに同時に処理するリクエスト数を制限するミドルウェア
+// rl.Limiterではないので、rl.Newには渡せない
+// 対象のリクエストの判定とキーの生成だけBaseLimiterを使い、カウンタとResponseHeadersは使わない
+type ConcurrencyLimiter struct {
+	name     string
+	keyFunc  KeyFunc
+	mu       sync.Mutex
+	inFlight map[string]int
+	base     BaseLimiter
+}
+
+// キーごとに同時に処理するリクエスト数を制限する
+// 頻度ではなく処理時間が問題になるエンドポイントに使う
+// keyにはRequestPathLimiterと同じ形式でremote_addrやhostなどを指定し、KeyByを指定した場合はそちらを優先する
+func NewConcurrencyLimiter(
+	maxInFlight int,
+	key string,
+	onRequestLimit func(*rl.Context, string) http.HandlerFunc,
+	setter ...Option,
+) (*ConcurrencyLimiter, error) {
+	if maxInFlight < 0 {
+		return nil, fmt.Errorf("invalid max in flight: %d", maxInFlight)
+	}
+	// カウンタは使わないので、期限切れを削除するgoroutineを起動しないよう最後に差し替える
+	setter = append([]Option{limiterKind("concurrency_limiter")}, append(setter, Counter(noCounter{}))...)
+	l := &ConcurrencyLimiter{
+		name:     "concurrency_limiter",
+		inFlight: map[string]int{},
+		base:     NewBaseLimiter(maxInFlight, concurrencyWindowLen, onRequestLimit, setter...),
+	}
+	if err := l.base.Err(); err != nil {
+		return nil, err
+	}
+	if l.base.limiterName != "" {
+		l.name = l.base.limiterName
+	}
+	if l.base.keyNamespace == "" {
+		l.base.keyNamespace = l.name
+	}
+	l.keyFunc = l.base.keyBy
+	if l.keyFunc == nil {
+		f, err := ParseKey(key)
+		if err != nil {
+			return nil, err
+		}
+		l.keyFunc = f
+	}
+	return l, nil
+}
+
+func (l *ConcurrencyLimiter) Name() string {
+	return l.name
+}
+
+// 同時に処理するリクエスト数を制限するミドルウェア
+// 上限に達している場合はonRequestLimitで応答し、処理中のリクエストが戻るかパニックした時に枠を解放する
+func (l *ConcurrencyLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := l.base.settings.Load()
+		if !l.base.isTargetRequest(s, r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, ok := l.base.key(r, l.keyFunc)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key = l.base.namespacedKey(key)
+		if !l.acquire(key, s.ReqLimit) {
+			l.base.onRequestLimit(&rl.Context{
+				StatusCode:   http.StatusTooManyRequests,
+				Err:          rl.ErrRateLimitExceeded,
+				RequestLimit: s.ReqLimit,
+				WindowLen:    s.WindowLen,
+				Next:         next,
+				Key:          key,
+			}, l.name)(w, r)
+			return
+		}
+		defer l.release(key)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// キーの処理中のリクエスト数を返す
+func (l *ConcurrencyLimiter) InFlight(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight[l.base.namespacedKey(key)]
+}
+
+func (l *ConcurrencyLimiter) acquire(key string, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight[key] >= limit {
+		return false
+	}
+	l.inFlight[key]++
+	return true
+}
+
+func (l *ConcurrencyLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight[key] <= 1 {
+		delete(l.inFlight, key)
+		return
+	}
+	l.inFlight[key]--
+}
+
+// 何も数えないカウンタ
+type noCounter struct{}
+
+func (noCounter) Get(string, time.Time) (int, error) { //nostyle:getters
+	return 0, nil
+}
+
+func (noCounter) Increment(string, time.Time) error {
+	return nil
+}
@@ -0,0 +1,132 @@
+package rlutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/2manymws/rl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	var limited []string
+	limiter, err := NewConcurrencyLimiter(2, RemoteAddrKey, func(c *rl.Context, name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			limited = append(limited, name+" "+c.Key)
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+	assert.NoError(t, err)
+
+	started := make(chan struct{})
+	done := make(chan struct{})
+	h := limiter.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-done
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(path, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, http.StatusOK, serve("/slow", "192.0.2.1:1234"))
+		}()
+		<-started
+	}
+	assert.Equal(t, 2, limiter.InFlight("192.0.2.1"))
+
+	assert.Equal(t, http.StatusTooManyRequests, serve("/", "192.0.2.1:1234"))
+	assert.Equal(t, []string{"concurrency_limiter concurrency_limiter:192.0.2.1"}, limited)
+	// キーが異なれば制限しない
+	assert.Equal(t, http.StatusOK, serve("/", "192.0.2.2:1234"))
+
+	close(done)
+	wg.Wait()
+	assert.Equal(t, 0, limiter.InFlight("192.0.2.1"))
+	assert.Equal(t, http.StatusOK, serve("/", "192.0.2.1:1234"))
+}
+
+func TestConcurrencyLimiter_Panic(t *testing.T) {
+	limiter, err := NewConcurrencyLimiter(1, HostKey, func(_ *rl.Context, _ string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+	assert.NoError(t, err)
+
+	h := limiter.Handler(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		panic("boom")
+	}))
+	for i := 0; i < 2; i++ {
+		assert.PanicsWithValue(t, "boom", func() {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+		})
+		assert.Equal(t, 0, limiter.InFlight("example.com"))
+	}
+}
+
+func TestConcurrencyLimiter_Options(t *testing.T) {
+	limiter, err := NewConcurrencyLimiter(0, RemoteAddrKey, func(_ *rl.Context, _ string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}, TargetMethods([]string{http.MethodPost}))
+	assert.NoError(t, err)
+
+	h := limiter.Handler(okHandler())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://example.com/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	// rl.Newには渡せない
+	_, ok := any(limiter).(rl.Limiter)
+	assert.False(t, ok)
+
+	_, err = NewConcurrencyLimiter(1, "unknown", nil)
+	assert.Error(t, err)
+	_, err = NewConcurrencyLimiter(-1, RemoteAddrKey, nil)
+	assert.Error(t, err)
+}
+
+func TestConcurrencyLimiter_KeyBy(t *testing.T) {
+	var limited []string
+	limiter, err := NewConcurrencyLimiter(0, RemoteAddrKey, func(c *rl.Context, name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			limited = append(limited, name+" "+c.Key)
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}, KeyBy(func(r *http.Request) (string, bool) {
+		v := r.Header.Get("X-User-Id")
+		return v, v != ""
+	}), LimiterName("export"))
+	assert.NoError(t, err)
+	assert.Equal(t, "export", limiter.Name())
+
+	// KeyByはkeyより優先され、キーを生成できないリクエストは制限しない
+	h := limiter.Handler(okHandler())
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	req.Header.Set("X-User-Id", "42")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, []string{"export export:42"}, limited)
+}
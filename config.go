@@ -0,0 +1,507 @@
+package rlutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/2manymws/rl"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	HostLimiterType          = "host"
+	IPLimiterType            = "ip"
+	UserAgentLimiterType     = "user_agent"
+	GetParameterLimiterType  = "get_parameter"
+	RequestPathLimiterType   = "request_path"
+	CountryLimiterType       = "country"
+	BodyParameterLimiterType = "body_parameter"
+	DenyListLimiterType      = "deny_list"
+)
+
+const (
+	FixedWindowAlgorithm = "fixed_window"
+	TokenBucketAlgorithm = "token_bucket"
+	GCRAAlgorithm        = "gcra"
+)
+
+// Config は設定ファイルで定義するリミッターの一覧
+// YAMLとJSONのどちらでも記述できる
+type Config struct {
+	Limiters []LimiterConfig `yaml:"limiters"`
+}
+
+// LimiterConfig はリミッター1つ分の設定
+// window_lenは"1m"のような時間の文字列で指定する
+// deny_listはreq_limitとwindow_lenを指定しなくてよい
+// response_headersにはnone, x_ratelimit, ietfのいずれかを指定する
+// algorithmにtoken_bucketを指定すると、req_limitをwindow_lenで割った速さで補充するトークンバケットで数え、burstを容量にする
+// gcraはreq_limitをwindow_lenで割った間隔でリクエストを許可し、burstの数だけ続けて許可する
+// request_path_template_keyにvaluesを指定すると、ルートテンプレートのプレースホルダの値ごとに数える
+// nameを指定するとリミッターの名前になり、key_namespaceを指定しない場合はキーの名前空間にもなる
+type LimiterConfig struct {
+	Name                   string                `yaml:"name"`
+	Type                   string                `yaml:"type"`
+	ReqLimit               int                   `yaml:"req_limit"`
+	WindowLen              time.Duration         `yaml:"window_len"`
+	Key                    string                `yaml:"key"`
+	KeyNamespace           string                `yaml:"key_namespace"`
+	ResponseHeaders        string                `yaml:"response_headers"`
+	Algorithm              string                `yaml:"algorithm"`
+	Burst                  int                   `yaml:"burst"`
+	TargetExtensions       []string              `yaml:"target_extensions"`
+	TargetMethods          []string              `yaml:"target_methods"`
+	IgnorePathContains     []string              `yaml:"ignore_path_contains"`
+	IgnorePathPrefixes     []string              `yaml:"ignore_path_prefixes"`
+	IgnorePathSuffixes     []string              `yaml:"ignore_path_suffixes"`
+	IgnorePathRegexps      []string              `yaml:"ignore_path_regexps"`
+	IgnorePathGlobs        []string              `yaml:"ignore_path_globs"`
+	TrustedProxies         []string              `yaml:"trusted_proxies"`
+	ForwardedHeader        string                `yaml:"forwarded_header"`
+	AllowCIDRs             []string              `yaml:"allow_cidrs"`
+	IPv4PrefixLen          int                   `yaml:"ipv4_prefix_len"`
+	IPv6PrefixLen          int                   `yaml:"ipv6_prefix_len"`
+	UserAgents             []string              `yaml:"user_agents"`
+	UserAgentRules         []UserAgentRule       `yaml:"user_agent_rules"`
+	BotCatalogPath         string                `yaml:"bot_catalog_path"`
+	VerifyCrawlers         *VerifyCrawlersConfig `yaml:"verify_crawlers"`
+	GetParameters          map[string]string     `yaml:"get_parameters"`
+	ParameterRules         []ParameterRule       `yaml:"parameter_rules"`
+	BodyParameters         map[string]string     `yaml:"body_parameters"`
+	MaxBodyBytes           int64                 `yaml:"max_body_bytes"`
+	RequestPathContains    []string              `yaml:"request_path_contains"`
+	RequestPathPrefixes    []string              `yaml:"request_path_prefixes"`
+	RequestPathSuffixes    []string              `yaml:"request_path_suffixes"`
+	RequestPathRegexps     []string              `yaml:"request_path_regexps"`
+	RequestPathGlobs       []string              `yaml:"request_path_globs"`
+	RequestPathTemplates   []string              `yaml:"request_path_templates"`
+	RequestPathTemplateKey string                `yaml:"request_path_template_key"`
+	PathRules              []PathRuleConfig      `yaml:"path_rules"`
+	DBPath                 string                `yaml:"db_path"`
+	Countries              []string              `yaml:"countries"`
+	SkipCountries          []string              `yaml:"skip_countries"`
+	DenyCIDRs              []string              `yaml:"deny_cidrs"`
+	DenyListPath           string                `yaml:"deny_list_path"`
+}
+
+// PathRuleConfig はrequest_pathのpath_rulesに記述するルール
+// matchにはprefix, suffix, contains, regexp, glob, templateのいずれかを指定する
+// key_modeにvaluesを指定すると、実際のパスまたはプレースホルダの値ごとに数える
+type PathRuleConfig struct {
+	Name      string        `yaml:"name"`
+	Match     string        `yaml:"match"`
+	Pattern   string        `yaml:"pattern"`
+	ReqLimit  int           `yaml:"req_limit"`
+	WindowLen time.Duration `yaml:"window_len"`
+	KeyMode   string        `yaml:"key_mode"`
+}
+
+// VerifyCrawlersConfig はuser_agentのverify_crawlersに記述する、クローラーの検証の設定
+// cache_ttlを省略した場合は検証結果を1時間キャッシュする
+// impostorにはなりすましを数えるname, req_limit, window_len, key_by_client_ipを指定する
+type VerifyCrawlersConfig struct {
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	Impostor UserAgentRule `yaml:"impostor"`
+}
+
+// 設定ファイルを読み込む
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(b)
+}
+
+// YAMLまたはJSONの設定を解釈して検証する
+func ParseConfig(b []byte) (*Config, error) {
+	c := &Config{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 設定ファイルからrl.Newに渡すリミッターを作成する
+func NewLimitersFromConfig(
+	path string,
+	onRequestLimit func(*rl.Context, string) http.HandlerFunc,
+	setter ...Option,
+) ([]rl.Limiter, error) {
+	c, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.Build(onRequestLimit, setter...)
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	names := map[string]struct{}{}
+	for i, lc := range c.Limiters {
+		if err := lc.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("limiters[%d]: %w", i, err))
+		}
+		if lc.Name == "" {
+			continue
+		}
+		if _, ok := names[lc.Name]; ok {
+			errs = append(errs, fmt.Errorf("limiters[%d]: duplicate name: %s", i, lc.Name))
+		}
+		names[lc.Name] = struct{}{}
+	}
+	return errors.Join(errs...)
+}
+
+// 設定に従ってリミッターを作成する
+// setterは全てのリミッターに共通で適用される
+func (c *Config) Build(
+	onRequestLimit func(*rl.Context, string) http.HandlerFunc,
+	setter ...Option,
+) ([]rl.Limiter, error) {
+	limiters := make([]rl.Limiter, 0, len(c.Limiters))
+	for i, lc := range c.Limiters {
+		l, err := lc.Build(onRequestLimit, setter...)
+		if err != nil {
+			// 呼び出し元は作成済みのリミッターを受け取れないので、ここで止める
+			for _, l := range limiters {
+				stopLimiter(l)
+			}
+			return nil, fmt.Errorf("limiters[%d]: %w", i, err)
+		}
+		limiters = append(limiters, l)
+	}
+	return limiters, nil
+}
+
+func (c LimiterConfig) Validate() error {
+	var errs []error
+	if err := c.settings().validate(); err != nil {
+		errs = append(errs, err)
+	}
+	switch c.Type {
+	case HostLimiterType, IPLimiterType:
+	case UserAgentLimiterType:
+		if len(c.UserAgents) == 0 && len(c.UserAgentRules) == 0 {
+			errs = append(errs, errors.New("one of user_agents or user_agent_rules is required"))
+		}
+	case GetParameterLimiterType:
+		if len(c.GetParameters) == 0 && len(c.ParameterRules) == 0 {
+			errs = append(errs, errors.New("one of get_parameters or parameter_rules is required"))
+		}
+	case BodyParameterLimiterType:
+		if len(c.BodyParameters) == 0 && len(c.ParameterRules) == 0 {
+			errs = append(errs, errors.New("one of body_parameters or parameter_rules is required"))
+		}
+	case RequestPathLimiterType:
+		if len(c.RequestPathContains) == 0 && len(c.RequestPathPrefixes) == 0 && len(c.RequestPathSuffixes) == 0 &&
+			len(c.RequestPathRegexps) == 0 && len(c.RequestPathGlobs) == 0 && len(c.RequestPathTemplates) == 0 &&
+			len(c.PathRules) == 0 {
+			errs = append(errs, errors.New("one of request_path_contains, request_path_prefixes, request_path_suffixes, request_path_regexps, request_path_globs, request_path_templates or path_rules is required"))
+		}
+		if _, err := routeKeyMode(c.RequestPathTemplateKey); err != nil {
+			errs = append(errs, fmt.Errorf("invalid request_path_template_key: %w", err))
+		}
+		for i, pr := range c.PathRules {
+			if _, err := routeKeyMode(pr.KeyMode); err != nil {
+				errs = append(errs, fmt.Errorf("path_rules[%d]: invalid key_mode: %w", i, err))
+			}
+		}
+	case CountryLimiterType:
+		if c.DBPath == "" {
+			errs = append(errs, errors.New("db_path is required"))
+		}
+		if len(c.Countries) == 0 {
+			errs = append(errs, errors.New("countries is required"))
+		}
+	case DenyListLimiterType:
+		if len(c.DenyCIDRs) == 0 && c.DenyListPath == "" {
+			errs = append(errs, errors.New("one of deny_cidrs or deny_list_path is required"))
+		}
+		for _, cidr := range c.DenyCIDRs {
+			if _, err := parsePrefix(cidr); err != nil {
+				errs = append(errs, fmt.Errorf("invalid deny CIDR: %s: %w", cidr, err))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid type: %q", c.Type))
+	}
+	errs = append(errs, c.validateTypeSpecificFields()...)
+	switch c.Algorithm {
+	case "", FixedWindowAlgorithm:
+		if c.Burst != 0 {
+			errs = append(errs, errors.New("burst requires algorithm: token_bucket or gcra"))
+		}
+	case TokenBucketAlgorithm, GCRAAlgorithm:
+	default:
+		errs = append(errs, fmt.Errorf("invalid algorithm: %q", c.Algorithm))
+	}
+	switch c.Type {
+	case GetParameterLimiterType, BodyParameterLimiterType, RequestPathLimiterType:
+		if _, err := ParseKey(c.Key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	options := Options{}
+	for _, setter := range c.options() {
+		setter(&options)
+	}
+	errs = append(errs, options.errs...)
+	if len(c.TrustedProxies) > 0 && c.ForwardedHeader == "" {
+		errs = append(errs, errors.New("forwarded_header is required with trusted_proxies"))
+	}
+	catalog := options.BotCatalog
+	if catalog == nil {
+		catalog = DefaultBotCatalog()
+	}
+	for _, rule := range options.UserAgentRules {
+		if rule.Match == UserAgentFamily && !catalog.HasFamily(BotFamily(rule.Pattern)) {
+			errs = append(errs, fmt.Errorf("user agent rule %s: unknown bot family: %s", rule.Name, rule.Pattern))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 種類ごとに固有の項目が、他の種類に指定されていないかを確認する
+// 他の種類のリミッターは固有の項目を使わないため、指定されても黙って無視されてしまう
+func (c LimiterConfig) validateTypeSpecificFields() []error {
+	var (
+		parameter   = []string{GetParameterLimiterType, BodyParameterLimiterType}
+		keyed       = []string{GetParameterLimiterType, BodyParameterLimiterType, RequestPathLimiterType}
+		userAgent   = []string{UserAgentLimiterType}
+		requestPath = []string{RequestPathLimiterType}
+		country     = []string{CountryLimiterType}
+		denyList    = []string{DenyListLimiterType}
+	)
+	fields := []struct {
+		name  string
+		set   bool
+		types []string
+	}{
+		{"key", c.Key != "", keyed},
+		{"user_agents", len(c.UserAgents) > 0, userAgent},
+		{"user_agent_rules", len(c.UserAgentRules) > 0, userAgent},
+		{"bot_catalog_path", c.BotCatalogPath != "", userAgent},
+		{"verify_crawlers", c.VerifyCrawlers != nil, userAgent},
+		{"get_parameters", len(c.GetParameters) > 0, []string{GetParameterLimiterType}},
+		{"parameter_rules", len(c.ParameterRules) > 0, parameter},
+		{"body_parameters", len(c.BodyParameters) > 0, []string{BodyParameterLimiterType}},
+		{"max_body_bytes", c.MaxBodyBytes != 0, []string{BodyParameterLimiterType}},
+		{"request_path_contains", len(c.RequestPathContains) > 0, requestPath},
+		{"request_path_prefixes", len(c.RequestPathPrefixes) > 0, requestPath},
+		{"request_path_suffixes", len(c.RequestPathSuffixes) > 0, requestPath},
+		{"request_path_regexps", len(c.RequestPathRegexps) > 0, requestPath},
+		{"request_path_globs", len(c.RequestPathGlobs) > 0, requestPath},
+		{"request_path_templates", len(c.RequestPathTemplates) > 0, requestPath},
+		{"request_path_template_key", c.RequestPathTemplateKey != "", requestPath},
+		{"path_rules", len(c.PathRules) > 0, requestPath},
+		{"db_path", c.DBPath != "", country},
+		{"countries", len(c.Countries) > 0, country},
+		{"skip_countries", len(c.SkipCountries) > 0, country},
+		{"deny_cidrs", len(c.DenyCIDRs) > 0, denyList},
+		{"deny_list_path", c.DenyListPath != "", denyList},
+	}
+	var errs []error
+	for _, f := range fields {
+		if f.set && !slices.Contains(f.types, c.Type) {
+			errs = append(errs, fmt.Errorf("%s is not supported by type: %s", f.name, c.Type))
+		}
+	}
+	return errs
+}
+
+// 設定に従ってリミッターを1つ作成する
+func (c LimiterConfig) Build(
+	onRequestLimit func(*rl.Context, string) http.HandlerFunc,
+	setter ...Option,
+) (rl.Limiter, error) {
+	setter = append(c.options(), setter...)
+	var (
+		l   rl.Limiter
+		err error
+	)
+	switch c.Type {
+	case HostLimiterType:
+		h := NewHostLimiter(c.ReqLimit, c.WindowLen, onRequestLimit, setter...)
+		l, err = h, h.Err()
+	case IPLimiterType:
+		ip := NewIPLimiter(c.ReqLimit, c.WindowLen, onRequestLimit, setter...)
+		l, err = ip, ip.Err()
+	case UserAgentLimiterType:
+		ua := NewUserAgentLimiter(c.UserAgents, c.ReqLimit, c.WindowLen, onRequestLimit, setter...)
+		l, err = ua, ua.Err()
+	case GetParameterLimiterType:
+		l, err = NewGetParameterLimiter(c.GetParameters, c.ReqLimit, c.WindowLen, c.Key, onRequestLimit, setter...)
+	case BodyParameterLimiterType:
+		l, err = NewBodyParameterLimiter(c.BodyParameters, c.ReqLimit, c.WindowLen, c.Key, onRequestLimit, setter...)
+	case RequestPathLimiterType:
+		l, err = NewRequestPathLimiter(
+			c.RequestPathContains,
+			c.RequestPathPrefixes,
+			c.RequestPathSuffixes,
+			c.ReqLimit,
+			c.WindowLen,
+			c.Key,
+			onRequestLimit,
+			setter...,
+		)
+	case CountryLimiterType:
+		l, err = NewCountryLimiter(c.DBPath, c.Countries, c.SkipCountries, c.ReqLimit, c.WindowLen, onRequestLimit, setter...)
+	case DenyListLimiterType:
+		var cidrs []string
+		if c.DenyListPath != "" {
+			if cidrs, err = readCIDRList(c.DenyListPath); err != nil {
+				return nil, err
+			}
+		}
+		l, err = NewDenyListLimiter(append(cidrs, c.DenyCIDRs...), onRequestLimit, setter...)
+	default:
+		return nil, fmt.Errorf("invalid type: %q", c.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// 実行中に変更できる設定を返す
+func (c LimiterConfig) settings() Settings {
+	s := Settings{
+		ReqLimit:           c.ReqLimit,
+		WindowLen:          c.WindowLen,
+		TargetExtensions:   c.TargetExtensions,
+		TargetMethods:      c.TargetMethods,
+		IgnorePathContains: c.IgnorePathContains,
+		IgnorePathPrefixes: c.IgnorePathPrefixes,
+		IgnorePathSuffixes: c.IgnorePathSuffixes,
+	}
+	if c.Type == DenyListLimiterType {
+		s.ReqLimit = 0
+		s.WindowLen = denyListWindowLen
+	}
+	return s
+}
+
+func (c LimiterConfig) options() []Option {
+	var options []Option
+	if len(c.TargetExtensions) > 0 {
+		options = append(options, TargetExtensions(c.TargetExtensions))
+	}
+	if len(c.TargetMethods) > 0 {
+		options = append(options, TargetMethods(c.TargetMethods))
+	}
+	if len(c.IgnorePathContains) > 0 {
+		options = append(options, IgnorePathContains(c.IgnorePathContains))
+	}
+	if len(c.IgnorePathPrefixes) > 0 {
+		options = append(options, IgnorePathPrefixes(c.IgnorePathPrefixes))
+	}
+	if len(c.IgnorePathSuffixes) > 0 {
+		options = append(options, IgnorePathSuffixes(c.IgnorePathSuffixes))
+	}
+	if len(c.IgnorePathRegexps) > 0 {
+		options = append(options, IgnorePathRegexps(c.IgnorePathRegexps))
+	}
+	if len(c.IgnorePathGlobs) > 0 {
+		options = append(options, IgnorePathGlobs(c.IgnorePathGlobs))
+	}
+	if len(c.RequestPathRegexps) > 0 {
+		options = append(options, RequestPathRegexps(c.RequestPathRegexps))
+	}
+	if len(c.RequestPathGlobs) > 0 {
+		options = append(options, RequestPathGlobs(c.RequestPathGlobs))
+	}
+	if len(c.RequestPathTemplates) > 0 {
+		// 不正なrequest_path_template_keyはValidateで検出する
+		mode, _ := routeKeyMode(c.RequestPathTemplateKey)
+		options = append(options, RequestPathTemplates(c.RequestPathTemplates, mode))
+	}
+	if len(c.PathRules) > 0 {
+		rules := make([]PathRule, 0, len(c.PathRules))
+		for _, pr := range c.PathRules {
+			// 不正なkey_modeはValidateで検出する
+			mode, _ := routeKeyMode(pr.KeyMode)
+			rules = append(rules, PathRule{
+				Name:      pr.Name,
+				Match:     PathMatchType(pr.Match),
+				Pattern:   pr.Pattern,
+				ReqLimit:  pr.ReqLimit,
+				WindowLen: pr.WindowLen,
+				KeyMode:   mode,
+			})
+		}
+		options = append(options, PathRules(rules))
+	}
+	if len(c.ParameterRules) > 0 {
+		options = append(options, ParameterRules(c.ParameterRules))
+	}
+	if len(c.UserAgentRules) > 0 {
+		options = append(options, UserAgentRules(c.UserAgentRules))
+	}
+	if c.BotCatalogPath != "" {
+		catalog, err := LoadBotCatalog(c.BotCatalogPath)
+		options = append(options, func(args *Options) {
+			if err != nil {
+				args.errs = append(args.errs, fmt.Errorf("invalid bot catalog: %w", err))
+				return
+			}
+			UseBotCatalog(catalog)(args)
+		})
+	}
+	if c.VerifyCrawlers != nil {
+		options = append(options, VerifyCrawlers(NewCrawlerVerifier(nil, c.VerifyCrawlers.CacheTTL), c.VerifyCrawlers.Impostor))
+	}
+	if c.MaxBodyBytes != 0 {
+		options = append(options, MaxBodyBytes(c.MaxBodyBytes))
+	}
+	if len(c.TrustedProxies) > 0 {
+		options = append(options, TrustedProxies(c.TrustedProxies))
+	}
+	if c.ForwardedHeader != "" {
+		options = append(options, ForwardedHeader(c.ForwardedHeader))
+	}
+	if len(c.AllowCIDRs) > 0 {
+		options = append(options, AllowCIDRs(c.AllowCIDRs))
+	}
+	if c.IPv4PrefixLen != 0 || c.IPv6PrefixLen != 0 {
+		options = append(options, IPPrefixLength(c.IPv4PrefixLen, c.IPv6PrefixLen))
+	}
+	if c.Name != "" {
+		options = append(options, LimiterName(c.Name))
+	}
+	if c.KeyNamespace != "" {
+		options = append(options, KeyNamespace(c.KeyNamespace))
+	}
+	if c.ResponseHeaders != "" {
+		options = append(options, ResponseHeaders(HeaderMode(c.ResponseHeaders)))
+	}
+	switch c.Algorithm {
+	case TokenBucketAlgorithm:
+		options = append(options, TokenBucket(c.Burst))
+	case GCRAAlgorithm:
+		options = append(options, GCRA(c.Burst))
+	}
+	return options
+}
+
+func routeKeyMode(s string) (RouteKeyMode, error) {
+	switch s {
+	case "", "template":
+		return RouteKeyTemplate, nil
+	case "values":
+		return RouteKeyValues, nil
+	default:
+		return RouteKeyTemplate, fmt.Errorf("%q", s)
+	}
+}
@@ -0,0 +1,524 @@
+package rlutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/2manymws/rl/counter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLimitersFromConfig(t *testing.T) {
+	limiters, err := NewLimitersFromConfig("testdata/limiters.yml", nil)
+	assert.NoError(t, err)
+
+	var names []string
+	for _, l := range limiters {
+		names = append(names, l.Name())
+	}
+	// nameを指定したリミッターはその名前になる
+	assert.Equal(t, []string{
+		"api_by_host",
+		"ip_limiter",
+		"user_agent_limiter",
+		"get_parameter_limiter",
+		"request_path_limiter",
+		"country_limiter",
+		"deny_list_limiter",
+	}, names)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/api/users", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+
+	host := limiters[0].(*HostLimiter)
+	assert.Equal(t, Settings{
+		ReqLimit:           1000,
+		WindowLen:          time.Minute,
+		TargetMethods:      []string{"get", "post"},
+		IgnorePathPrefixes: []string{"/health"},
+	}, host.Settings())
+
+	rule, err := limiters[1].Rule(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "ip_limiter:192.0.2.0/24", rule.Key)
+	assert.Equal(t, 100, rule.ReqLimit)
+
+	rule, err = limiters[4].Rule(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "api:example.com+10.0.0.1/api/", rule.Key)
+	assert.Equal(t, 10*time.Second, rule.WindowLen)
+
+	for _, addr := range []string{"198.51.100.1", "192.0.2.200"} {
+		req.Header.Set("X-Forwarded-For", addr)
+		rule, err = limiters[6].Rule(req)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, rule.ReqLimit)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    []LimiterConfig
+		wantErr bool
+	}{
+		{
+			name: "YAML",
+			config: `
+limiters:
+  - type: host
+    req_limit: 10
+    window_len: 1m
+`,
+			want: []LimiterConfig{{Type: "host", ReqLimit: 10, WindowLen: time.Minute}},
+		},
+		{
+			name:   "JSON",
+			config: `{"limiters": [{"type": "ip", "req_limit": 10, "window_len": "30s", "target_extensions": ["html"]}]}`,
+			want:   []LimiterConfig{{Type: "ip", ReqLimit: 10, WindowLen: 30 * time.Second, TargetExtensions: []string{"html"}}},
+		},
+		{
+			name:   "Empty",
+			config: ``,
+		},
+		{
+			name:    "Unknown type",
+			config:  `{"limiters": [{"type": "unknown", "req_limit": 10, "window_len": "1m"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Unknown field",
+			config:  `{"limiters": [{"type": "host", "req_limt": 10, "window_len": "1m"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Missing window_len",
+			config:  `{"limiters": [{"type": "host", "req_limit": 10}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid window_len",
+			config:  `{"limiters": [{"type": "host", "req_limit": 10, "window_len": "1 minute"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Negative req_limit",
+			config:  `{"limiters": [{"type": "host", "req_limit": -1, "window_len": "1m"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Missing user_agents",
+			config:  `{"limiters": [{"type": "user_agent", "req_limit": 10, "window_len": "1m"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Missing get_parameters",
+			config:  `{"limiters": [{"type": "get_parameter", "req_limit": 10, "window_len": "1m", "key": "host"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Missing request paths",
+			config:  `{"limiters": [{"type": "request_path", "req_limit": 10, "window_len": "1m", "key": "host"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid key",
+			config:  `{"limiters": [{"type": "request_path", "req_limit": 10, "window_len": "1m", "key": "unknown", "request_path_prefixes": ["/"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Missing db_path and countries",
+			config:  `{"limiters": [{"type": "country", "req_limit": 10, "window_len": "1m"}]}`,
+			wantErr: true,
+		},
+		{
+			name:   "Deny list without window_len",
+			config: `{"limiters": [{"type": "deny_list", "deny_cidrs": ["192.0.2.0/24"]}]}`,
+			want:   []LimiterConfig{{Type: "deny_list", DenyCIDRs: []string{"192.0.2.0/24"}}},
+		},
+		{
+			name:    "Missing deny list",
+			config:  `{"limiters": [{"type": "deny_list"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid deny CIDR",
+			config:  `{"limiters": [{"type": "deny_list", "deny_cidrs": ["192.0.2.0/33"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:   "Request path globs only",
+			config: `{"limiters": [{"type": "request_path", "req_limit": 10, "window_len": "1m", "key": "host", "request_path_globs": ["/api/*/search"]}]}`,
+			want: []LimiterConfig{{
+				Type: "request_path", ReqLimit: 10, WindowLen: time.Minute, Key: "host", RequestPathGlobs: []string{"/api/*/search"},
+			}},
+		},
+		{
+			name: "Path rules",
+			config: `
+limiters:
+  - type: request_path
+    req_limit: 100
+    window_len: 1m
+    key: host
+    path_rules:
+      - name: search
+        match: prefix
+        pattern: /search
+        req_limit: 10
+        window_len: 10s
+      - match: template
+        pattern: /users/{id}/export
+        req_limit: 1
+        window_len: 1h
+        key_mode: values
+`,
+			want: []LimiterConfig{{
+				Type: "request_path", ReqLimit: 100, WindowLen: time.Minute, Key: "host",
+				PathRules: []PathRuleConfig{
+					{Name: "search", Match: "prefix", Pattern: "/search", ReqLimit: 10, WindowLen: 10 * time.Second},
+					{Match: "template", Pattern: "/users/{id}/export", ReqLimit: 1, WindowLen: time.Hour, KeyMode: "values"},
+				},
+			}},
+		},
+		{
+			name:    "Invalid path rule key mode",
+			config:  `{"limiters": [{"type": "request_path", "req_limit": 10, "window_len": "1m", "key": "host", "path_rules": [{"match": "prefix", "pattern": "/a", "req_limit": 1, "window_len": "1m", "key_mode": "path"}]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid path rule",
+			config:  `{"limiters": [{"type": "request_path", "req_limit": 10, "window_len": "1m", "key": "host", "path_rules": [{"match": "exact", "pattern": "/a", "req_limit": 1, "window_len": "1m"}]}]}`,
+			wantErr: true,
+		},
+		{
+			name: "Parameter rules",
+			config: `
+limiters:
+  - type: get_parameter
+    req_limit: 10
+    window_len: 1m
+    key: host
+    parameter_rules:
+      - conditions:
+          - {name: q, match: present}
+          - {name: sort, match: any_of, values: [price, date]}
+`,
+			want: []LimiterConfig{{
+				Type: "get_parameter", ReqLimit: 10, WindowLen: time.Minute, Key: "host",
+				ParameterRules: []ParameterRule{{Conditions: []ParameterCondition{
+					{Name: "q", Match: ParameterPresent},
+					{Name: "sort", Match: ParameterAnyOf, Values: []string{"price", "date"}},
+				}}},
+			}},
+		},
+		{
+			name:   "Body parameters",
+			config: `{"limiters": [{"type": "body_parameter", "req_limit": 10, "window_len": "1m", "key": "host", "body_parameters": {"/user/id": "1"}, "max_body_bytes": 1024}]}`,
+			want: []LimiterConfig{{
+				Type: "body_parameter", ReqLimit: 10, WindowLen: time.Minute, Key: "host",
+				BodyParameters: map[string]string{"/user/id": "1"}, MaxBodyBytes: 1024,
+			}},
+		},
+		{
+			name:    "Missing body parameters",
+			config:  `{"limiters": [{"type": "body_parameter", "req_limit": 10, "window_len": "1m", "key": "host"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid max body bytes",
+			config:  `{"limiters": [{"type": "body_parameter", "req_limit": 10, "window_len": "1m", "key": "host", "body_parameters": {"a": "1"}, "max_body_bytes": -1}]}`,
+			wantErr: true,
+		},
+		{
+			name: "User agent rules",
+			config: `
+limiters:
+  - type: user_agent
+    req_limit: 10
+    window_len: 1m
+    user_agent_rules:
+      - {match: regexp, pattern: '(?i)spider', req_limit: 5, window_len: 10s, key_by_client_ip: true}
+`,
+			want: []LimiterConfig{{
+				Type: "user_agent", ReqLimit: 10, WindowLen: time.Minute,
+				UserAgentRules: []UserAgentRule{
+					{Match: UserAgentRegexp, Pattern: "(?i)spider", ReqLimit: 5, WindowLen: 10 * time.Second, KeyByClientIP: true},
+				},
+			}},
+		},
+		{
+			name: "Verify crawlers",
+			config: `
+limiters:
+  - type: user_agent
+    req_limit: 10
+    window_len: 1m
+    user_agent_rules:
+      - {match: family, pattern: search_engine, req_limit: 100, window_len: 1m}
+    verify_crawlers:
+      cache_ttl: 30m
+      impostor: {req_limit: 1, window_len: 1h, key_by_client_ip: true}
+`,
+			want: []LimiterConfig{{
+				Type: "user_agent", ReqLimit: 10, WindowLen: time.Minute,
+				UserAgentRules: []UserAgentRule{
+					{Match: UserAgentFamily, Pattern: "search_engine", ReqLimit: 100, WindowLen: time.Minute},
+				},
+				VerifyCrawlers: &VerifyCrawlersConfig{
+					CacheTTL: 30 * time.Minute,
+					Impostor: UserAgentRule{ReqLimit: 1, WindowLen: time.Hour, KeyByClientIP: true},
+				},
+			}},
+		},
+		{
+			name:    "Invalid impostor rule",
+			config:  `{"limiters": [{"type": "user_agent", "req_limit": 10, "window_len": "1m", "user_agents": ["bot"], "verify_crawlers": {"impostor": {"req_limit": 1}}}]}`,
+			wantErr: true,
+		},
+		{
+			name:   "Response headers",
+			config: `{"limiters": [{"type": "host", "req_limit": 10, "window_len": "1m", "response_headers": "ietf"}]}`,
+			want: []LimiterConfig{{
+				Type: "host", ReqLimit: 10, WindowLen: time.Minute, ResponseHeaders: "ietf",
+			}},
+		},
+		{
+			name:   "Token bucket",
+			config: `{"limiters": [{"type": "host", "req_limit": 10, "window_len": "1m", "algorithm": "token_bucket", "burst": 20}]}`,
+			want: []LimiterConfig{{
+				Type: "host", ReqLimit: 10, WindowLen: time.Minute, Algorithm: "token_bucket", Burst: 20,
+			}},
+		},
+		{
+			name:   "GCRA",
+			config: `{"limiters": [{"type": "ip", "req_limit": 5, "window_len": "1m", "algorithm": "gcra", "burst": 1}, {"type": "request_path", "req_limit": 3, "window_len": "1m", "key": "remote_addr", "request_path_prefixes": ["/login"], "algorithm": "gcra"}]}`,
+			want: []LimiterConfig{
+				{Type: "ip", ReqLimit: 5, WindowLen: time.Minute, Algorithm: "gcra", Burst: 1},
+				{Type: "request_path", ReqLimit: 3, WindowLen: time.Minute, Key: "remote_addr", RequestPathPrefixes: []string{"/login"}, Algorithm: "gcra"},
+			},
+		},
+		{
+			name:    "Burst with fixed window",
+			config:  `{"limiters": [{"type": "ip", "req_limit": 5, "window_len": "1m", "algorithm": "fixed_window", "burst": 1}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Sliding log is not supported",
+			config:  `{"limiters": [{"type": "ip", "req_limit": 5, "window_len": "1m", "algorithm": "sliding_log"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid algorithm",
+			config:  `{"limiters": [{"type": "host", "req_limit": 10, "window_len": "1m", "algorithm": "leaky_bucket"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Burst without token bucket",
+			config:  `{"limiters": [{"type": "host", "req_limit": 10, "window_len": "1m", "burst": 20}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Fields of another type",
+			config:  `{"limiters": [{"type": "ip", "req_limit": 10, "window_len": "1m", "path_rules": [{"name": "search", "match": "prefix", "pattern": "/search", "req_limit": 10, "window_len": "1m"}], "user_agents": ["TestBot"], "request_path_prefixes": ["/api/"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Key on ip",
+			config:  `{"limiters": [{"type": "ip", "req_limit": 10, "window_len": "1m", "key": "host"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid forwarded header",
+			config:  `{"limiters": [{"type": "ip", "req_limit": 10, "window_len": "1m", "trusted_proxies": ["10.0.0.0/8"], "forwarded_header": "X-Client-IP"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid response headers",
+			config:  `{"limiters": [{"type": "host", "req_limit": 10, "window_len": "1m", "response_headers": "draft"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Unknown bot family",
+			config:  `{"limiters": [{"type": "user_agent", "req_limit": 10, "window_len": "1m", "user_agent_rules": [{"match": "family", "pattern": "internal", "req_limit": 1, "window_len": "1m"}]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Unknown bot catalog",
+			config:  `{"limiters": [{"type": "user_agent", "req_limit": 10, "window_len": "1m", "user_agents": ["bot"], "bot_catalog_path": "testdata/not_found.yml"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid user agent rule",
+			config:  `{"limiters": [{"type": "user_agent", "req_limit": 10, "window_len": "1m", "user_agent_rules": [{"match": "regexp", "pattern": "(", "req_limit": 1, "window_len": "1m"}]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid parameter rule",
+			config:  `{"limiters": [{"type": "get_parameter", "req_limit": 10, "window_len": "1m", "key": "host", "parameter_rules": [{"conditions": [{"name": "q", "match": "regexp", "value": "("}]}]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid request path template",
+			config:  `{"limiters": [{"type": "request_path", "req_limit": 10, "window_len": "1m", "key": "host", "request_path_templates": ["/users/{id"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid request path template key",
+			config:  `{"limiters": [{"type": "request_path", "req_limit": 10, "window_len": "1m", "key": "host", "request_path_templates": ["/users/{id}"], "request_path_template_key": "id"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid ignore path regexp",
+			config:  `{"limiters": [{"type": "ip", "req_limit": 10, "window_len": "1m", "ignore_path_regexps": ["^/api/(v1"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid request path glob",
+			config:  `{"limiters": [{"type": "request_path", "req_limit": 10, "window_len": "1m", "key": "host", "request_path_globs": ["/api/[a-"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid trusted proxy",
+			config:  `{"limiters": [{"type": "ip", "req_limit": 10, "window_len": "1m", "trusted_proxies": ["10.0.0.0/33"], "forwarded_header": "X-Forwarded-For"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Trusted proxies without forwarded header",
+			config:  `{"limiters": [{"type": "ip", "req_limit": 10, "window_len": "1m", "trusted_proxies": ["10.0.0.0/8"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid allow CIDR",
+			config:  `{"limiters": [{"type": "ip", "req_limit": 10, "window_len": "1m", "allow_cidrs": ["health-check"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid prefix length",
+			config:  `{"limiters": [{"type": "ip", "req_limit": 10, "window_len": "1m", "ipv4_prefix_len": 33}]}`,
+			wantErr: true,
+		},
+		{
+			name: "Duplicate name",
+			config: `{"limiters": [
+				{"name": "a", "type": "ip", "req_limit": 10, "window_len": "1m"},
+				{"name": "a", "type": "host", "req_limit": 10, "window_len": "1m"}
+			]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseConfig([]byte(tt.config))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, c.Limiters)
+		})
+	}
+}
+
+func TestLimiterConfigBuildError(t *testing.T) {
+	_, err := LimiterConfig{
+		Type:      CountryLimiterType,
+		ReqLimit:  10,
+		WindowLen: time.Minute,
+		DBPath:    "testdata/not_found.mmdb",
+		Countries: []string{"US"},
+	}.Build(nil)
+	assert.Error(t, err)
+}
+
+func TestConfigBuildErrorStop(t *testing.T) {
+	c, err := ParseConfig([]byte(`
+limiters:
+  - type: ip
+    req_limit: 10
+    window_len: 1m
+    algorithm: gcra
+  - type: country
+    req_limit: 10
+    window_len: 1m
+    db_path: testdata/not_found.mmdb
+    countries: [US]
+`))
+	assert.NoError(t, err)
+	before := runtime.NumGoroutine()
+	// 途中で失敗した場合は、作成済みのリミッターのgoroutineを止める
+	for i := 0; i < 10; i++ {
+		_, err := c.Build(nil)
+		assert.Error(t, err)
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestParseConfigNoGoroutine(t *testing.T) {
+	config := []byte(`
+limiters:
+  - type: ip
+    req_limit: 10
+    window_len: 1m
+    algorithm: token_bucket
+  - type: host
+    req_limit: 10
+    window_len: 1m
+    algorithm: gcra
+  - type: host
+    name: host_fixed_window
+    req_limit: 10
+    window_len: 1m
+`)
+	before := runtime.NumGoroutine()
+	// 検証するだけではカウンタを作成しない
+	for i := 0; i < 20; i++ {
+		_, err := ParseConfig(config)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, before, runtime.NumGoroutine())
+}
+
+func TestLimiterConfigBuildName(t *testing.T) {
+	c, err := ParseConfig([]byte(`
+limiters:
+  - type: ip
+    name: ip_short
+    req_limit: 10
+    window_len: 1m
+  - type: ip
+    name: ip_long
+    req_limit: 100
+    window_len: 1h
+  - type: ip
+    name: ip_shared
+    key_namespace: shared
+    req_limit: 100
+    window_len: 1h
+`))
+	assert.NoError(t, err)
+	limiters, err := c.Build(nil, Counter(counter.New(2*time.Hour)))
+	assert.NoError(t, err)
+
+	// カウンタを共有しても、名前ごとに別のキーで数える
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	var names, keys []string
+	for _, l := range limiters {
+		rule, err := l.Rule(req)
+		assert.NoError(t, err)
+		names = append(names, l.Name())
+		keys = append(keys, rule.Key)
+	}
+	assert.Equal(t, []string{"ip_short", "ip_long", "ip_shared"}, names)
+	assert.Equal(t, []string{"ip_short:192.0.2.1", "ip_long:192.0.2.1", "shared:192.0.2.1"}, keys)
+}
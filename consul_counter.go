@@ -0,0 +1,163 @@
+package rlutils
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/2manymws/rl"
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	consulMinSessionTTL = 10 * time.Second
+	consulMaxSessionTTL = 24 * time.Hour
+	consulMaxCASRetries = 16
+)
+
+type consulKV interface {
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+}
+
+type consulTxn interface {
+	Txn(txn api.TxnOps, q *api.QueryOptions) (bool, *api.TxnResponse, *api.QueryMeta, error)
+}
+
+type consulSession interface {
+	Create(se *api.SessionEntry, q *api.WriteOptions) (string, *api.WriteMeta, error)
+}
+
+// ConsulCounter はConsul KVにウィンドウごとのリクエスト数を保存するカウンタ
+// 複数のプロセスで同じプレフィックスを使うと、プロセスをまたいでリクエスト数を数えられる
+type ConsulCounter struct {
+	kv       consulKV
+	txn      consulTxn
+	session  consulSession
+	prefix   string
+	ttl      time.Duration
+	mu       sync.Mutex
+	sessions map[int64]string
+}
+
+var _ rl.Counter = (*ConsulCounter)(nil)
+
+// Consul KVを使うカウンタを作成する
+// 各ウィンドウのキーはttlを持つセッションに紐づき、セッションの失効とともに削除される
+// ttlには最低でもウィンドウ2回分の長さを指定する
+func NewConsulCounter(client *api.Client, prefix string, ttl time.Duration) *ConsulCounter {
+	return newConsulCounter(client.KV(), client.Txn(), client.Session(), prefix, ttl)
+}
+
+func newConsulCounter(kv consulKV, txn consulTxn, session consulSession, prefix string, ttl time.Duration) *ConsulCounter {
+	if ttl < consulMinSessionTTL {
+		ttl = consulMinSessionTTL
+	}
+	if ttl > consulMaxSessionTTL {
+		ttl = consulMaxSessionTTL
+	}
+	return &ConsulCounter{
+		kv:       kv,
+		txn:      txn,
+		session:  session,
+		prefix:   prefix,
+		ttl:      ttl,
+		sessions: map[int64]string{},
+	}
+}
+
+func (c *ConsulCounter) Get(key string, window time.Time) (int, error) { //nostyle:getters
+	pair, _, err := c.kv.Get(c.kvKey(key, window), nil)
+	if err != nil {
+		return 0, err
+	}
+	if pair == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(string(pair.Value))
+}
+
+// キーが存在しなければセッションに紐づけて作成し、存在すればCASで加算する
+// 他のプロセスと競合した場合はやり直す
+func (c *ConsulCounter) Increment(key string, currWindow time.Time) error {
+	k := c.kvKey(key, currWindow)
+	createFailed := false
+	for i := 0; i < consulMaxCASRetries; i++ {
+		pair, _, err := c.kv.Get(k, nil)
+		if err != nil {
+			return err
+		}
+
+		var ops api.TxnOps
+		if pair == nil {
+			if createFailed {
+				// 作成に失敗したのにキーが存在しない場合は、セッションが失効しているので作り直す
+				c.forgetSession(currWindow)
+			}
+			sessionID, err := c.windowSession(currWindow)
+			if err != nil {
+				return err
+			}
+			value := []byte("1")
+			ops = api.TxnOps{
+				{KV: &api.KVTxnOp{Verb: api.KVCAS, Key: k, Value: value, Index: 0}},
+				{KV: &api.KVTxnOp{Verb: api.KVLock, Key: k, Value: value, Session: sessionID}},
+			}
+		} else {
+			n, err := strconv.Atoi(string(pair.Value))
+			if err != nil {
+				return err
+			}
+			ops = api.TxnOps{
+				{KV: &api.KVTxnOp{Verb: api.KVCAS, Key: k, Value: []byte(strconv.Itoa(n + 1)), Index: pair.ModifyIndex}},
+			}
+		}
+
+		ok, _, _, err := c.txn.Txn(ops, nil)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		createFailed = pair == nil
+	}
+	return fmt.Errorf("failed to increment %s: too many conflicts", k)
+}
+
+// ウィンドウごとのセッションを返す
+// セッションはttl経過後に失効し、紐づくキーが削除される
+func (c *ConsulCounter) windowSession(window time.Time) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := window.Unix()
+	if id, ok := c.sessions[w]; ok {
+		return id, nil
+	}
+	id, _, err := c.session.Create(&api.SessionEntry{
+		Name:     c.prefix,
+		Behavior: api.SessionBehaviorDelete,
+		TTL:      c.ttl.String(),
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	for sw := range c.sessions {
+		if time.Unix(sw, 0).Add(c.ttl).Before(window) {
+			delete(c.sessions, sw)
+		}
+	}
+	c.sessions[w] = id
+	return id, nil
+}
+
+func (c *ConsulCounter) forgetSession(window time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sessions, window.Unix())
+}
+
+func (c *ConsulCounter) kvKey(key string, window time.Time) string {
+	return fmt.Sprintf("%s/%s-%d", c.prefix, key, window.Unix())
+}
@@ -0,0 +1,58 @@
+//go:build consul
+
+package rlutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/2manymws/rl"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// consulのバイナリが必要なため、go test -tags consul で実行する
+func TestConsulCounterWithTestServer(t *testing.T) {
+	srv, err := testutil.NewTestServerConfigT(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	client, err := api.NewClient(&api.Config{Address: srv.HTTPAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 別々のプロセスを想定し、同じプレフィックスを使う2つのカウンタでリクエスト数を共有する
+	var handlers []http.Handler
+	for i := 0; i < 2; i++ {
+		limiter := NewIPLimiter(
+			3,
+			time.Minute,
+			func(_ *rl.Context, _ string) http.HandlerFunc {
+				return func(w http.ResponseWriter, _ *http.Request) {
+					w.WriteHeader(http.StatusTooManyRequests)
+				}
+			},
+			Counter(NewConsulCounter(client, "rlutils", 2*time.Minute)),
+		)
+		if err := limiter.Err(); err != nil {
+			t.Fatal(err)
+		}
+		handlers = append(handlers, rl.New(limiter)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})))
+	}
+
+	var codes []int
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		handlers[i%2].ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+}
@@ -0,0 +1,167 @@
+package rlutils
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConsul はConsulCounterが利用するKV、トランザクション、セッションの操作をメモリ上で再現する
+type fakeConsul struct {
+	mu       sync.Mutex
+	index    uint64
+	pairs    map[string]*api.KVPair
+	sessions map[string]*api.SessionEntry
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		pairs:    map[string]*api.KVPair{},
+		sessions: map[string]*api.SessionEntry{},
+	}
+}
+
+func (f *fakeConsul) Get(key string, _ *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.pairs[key]
+	if !ok {
+		return nil, &api.QueryMeta{}, nil
+	}
+	c := *p
+	return &c, &api.QueryMeta{}, nil
+}
+
+func (f *fakeConsul) Txn(txn api.TxnOps, _ *api.QueryOptions) (bool, *api.TxnResponse, *api.QueryMeta, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pairs := map[string]*api.KVPair{}
+	for k, v := range f.pairs {
+		c := *v
+		pairs[k] = &c
+	}
+	index := f.index
+	for _, op := range txn {
+		index++
+		p, exists := pairs[op.KV.Key]
+		switch op.KV.Verb {
+		case api.KVCAS:
+			if (op.KV.Index == 0 && exists) || (op.KV.Index != 0 && (!exists || p.ModifyIndex != op.KV.Index)) {
+				return false, &api.TxnResponse{}, &api.QueryMeta{}, nil
+			}
+			if !exists {
+				p = &api.KVPair{Key: op.KV.Key}
+				pairs[op.KV.Key] = p
+			}
+			p.Value = op.KV.Value
+			p.ModifyIndex = index
+		case api.KVLock:
+			if _, ok := f.sessions[op.KV.Session]; !ok || !exists {
+				return false, &api.TxnResponse{}, &api.QueryMeta{}, nil
+			}
+			p.Value = op.KV.Value
+			p.Session = op.KV.Session
+			p.ModifyIndex = index
+		default:
+			return false, nil, nil, fmt.Errorf("unsupported verb: %s", op.KV.Verb)
+		}
+	}
+	f.pairs = pairs
+	f.index = index
+	return true, &api.TxnResponse{}, &api.QueryMeta{}, nil
+}
+
+func (f *fakeConsul) Create(se *api.SessionEntry, _ *api.WriteOptions) (string, *api.WriteMeta, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := fmt.Sprintf("session-%d", len(f.sessions)+1)
+	f.sessions[id] = se
+	return id, &api.WriteMeta{}, nil
+}
+
+// セッションを失効させ、紐づくキーを削除する
+func (f *fakeConsul) invalidate(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.sessions, id)
+	for k, p := range f.pairs {
+		if p.Session == id {
+			delete(f.pairs, k)
+		}
+	}
+}
+
+func TestConsulCounter(t *testing.T) {
+	f := newFakeConsul()
+	c := newConsulCounter(f, f, f, "rlutils", 2*time.Minute)
+	window := time.Now().Truncate(time.Minute)
+
+	got, err := c.Get("ip_limiter:192.0.2.1", window)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, got)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, c.Increment("ip_limiter:192.0.2.1", window))
+	}
+	got, err = c.Get("ip_limiter:192.0.2.1", window)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, got)
+
+	got, err = c.Get("ip_limiter:192.0.2.1", window.Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, got)
+
+	p, _, err := f.Get(fmt.Sprintf("rlutils/ip_limiter:192.0.2.1-%d", window.Unix()), nil)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, p.Session)
+	assert.Equal(t, api.SessionBehaviorDelete, f.sessions[p.Session].Behavior)
+	assert.Equal(t, "2m0s", f.sessions[p.Session].TTL)
+}
+
+func TestConsulCounterConcurrentIncrement(t *testing.T) {
+	f := newFakeConsul()
+	c := newConsulCounter(f, f, f, "rlutils", 2*time.Minute)
+	window := time.Now().Truncate(time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				assert.NoError(t, c.Increment("key", window))
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := c.Get("key", window)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, got)
+}
+
+func TestConsulCounterSessionExpired(t *testing.T) {
+	f := newFakeConsul()
+	c := newConsulCounter(f, f, f, "rlutils", 2*time.Minute)
+	window := time.Now().Truncate(time.Minute)
+
+	assert.NoError(t, c.Increment("key", window))
+	p, _, err := f.Get(fmt.Sprintf("rlutils/key-%d", window.Unix()), nil)
+	assert.NoError(t, err)
+	f.invalidate(p.Session)
+
+	assert.NoError(t, c.Increment("key", window))
+	got, err := c.Get("key", window)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, got)
+}
+
+func TestConsulCounterTTL(t *testing.T) {
+	f := newFakeConsul()
+	assert.Equal(t, 10*time.Second, newConsulCounter(f, f, f, "rlutils", time.Second).ttl)
+	assert.Equal(t, 24*time.Hour, newConsulCounter(f, f, f, "rlutils", 48*time.Hour).ttl)
+}
@@ -0,0 +1,221 @@
+package rlutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	ConsulReqLimitKey           = "req_limit"
+	ConsulWindowLenKey          = "window_len"
+	ConsulTargetExtensionsKey   = "target_extensions"
+	ConsulTargetMethodsKey      = "target_methods"
+	ConsulIgnorePathContainsKey = "ignore_path_contains"
+	ConsulIgnorePathPrefixesKey = "ignore_path_prefixes"
+	ConsulIgnorePathSuffixesKey = "ignore_path_suffixes"
+
+	defaultConsulWaitTime      = 5 * time.Minute
+	defaultConsulRetryInterval = 5 * time.Second
+)
+
+// SettingsUpdater は設定を実行中に差し替えられるリミッター
+type SettingsUpdater interface {
+	Settings() Settings
+	UpdateSettings(Settings) error
+}
+
+type consulKVList interface {
+	List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+}
+
+// ConsulSettingsWatcher はConsul KVのプレフィックス以下からリミッターの設定を読み込み、変更を監視する
+// プレフィックス以下のreq_limit, window_len, target_extensionsなどのキーで設定を上書きする
+// キーが存在しない項目はリミッター作成時の設定に戻る
+type ConsulSettingsWatcher struct {
+	kv            consulKVList
+	prefix        string
+	limiters      []SettingsUpdater
+	defaults      []Settings
+	waitTime      time.Duration
+	retryInterval time.Duration
+	onError       func(error)
+}
+
+type ConsulSettingsWatcherOption func(*ConsulSettingsWatcher)
+
+// 変更の監視中に発生したエラーを受け取る関数を指定する
+// エラーが発生しても直前に適用できた設定が使われ続ける
+func OnConsulSettingsError(f func(error)) ConsulSettingsWatcherOption {
+	return func(w *ConsulSettingsWatcher) {
+		w.onError = f
+	}
+}
+
+// Consulに接続できない場合に再試行するまでの間隔を指定する
+func ConsulSettingsRetryInterval(d time.Duration) ConsulSettingsWatcherOption {
+	return func(w *ConsulSettingsWatcher) {
+		w.retryInterval = d
+	}
+}
+
+// Consul KVの設定を監視してリミッターに適用する
+func NewConsulSettingsWatcher(
+	client *api.Client,
+	prefix string,
+	limiters []SettingsUpdater,
+	setters ...ConsulSettingsWatcherOption,
+) *ConsulSettingsWatcher {
+	return newConsulSettingsWatcher(client.KV(), prefix, limiters, setters...)
+}
+
+func newConsulSettingsWatcher(
+	kv consulKVList,
+	prefix string,
+	limiters []SettingsUpdater,
+	setters ...ConsulSettingsWatcherOption,
+) *ConsulSettingsWatcher {
+	w := &ConsulSettingsWatcher{
+		kv:            kv,
+		prefix:        strings.TrimSuffix(prefix, "/") + "/",
+		limiters:      limiters,
+		waitTime:      defaultConsulWaitTime,
+		retryInterval: defaultConsulRetryInterval,
+		onError:       func(error) {},
+	}
+	for _, l := range limiters {
+		w.defaults = append(w.defaults, l.Settings())
+	}
+	for _, setter := range setters {
+		if setter != nil {
+			setter(w)
+		}
+	}
+	return w
+}
+
+// 現在のConsul KVの設定を読み込んで適用する
+func (w *ConsulSettingsWatcher) Load(ctx context.Context) error {
+	_, err := w.fetch(ctx, 0)
+	return err
+}
+
+// ブロッキングクエリでConsul KVの変更を監視し、変更があるたびに設定を適用する
+// ctxがキャンセルされるまで戻らない
+func (w *ConsulSettingsWatcher) Run(ctx context.Context) error {
+	var index uint64
+	for {
+		next, err := w.fetch(ctx, index)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			w.onError(err)
+			if next == 0 {
+				// Consulに接続できないので、時間をおいて再試行する
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(w.retryInterval):
+				}
+			}
+		}
+		index = next
+	}
+}
+
+// indexより新しい設定を待って適用し、次に待つべきインデックスを返す
+// 接続に失敗した場合は0を返す
+func (w *ConsulSettingsWatcher) fetch(ctx context.Context, index uint64) (uint64, error) {
+	q := (&api.QueryOptions{WaitIndex: index, WaitTime: w.waitTime}).WithContext(ctx)
+	pairs, meta, err := w.kv.List(w.prefix, q)
+	if err != nil {
+		return 0, err
+	}
+	next := meta.LastIndex
+	if next < index {
+		// インデックスが巻き戻った場合は最初から監視し直す
+		return 0, nil
+	}
+	if index != 0 && next == index {
+		// 変更がないままタイムアウトした
+		return next, nil
+	}
+	return next, w.apply(pairs)
+}
+
+// 全てのリミッターの設定を検証してから差し替える
+// いずれかの設定が不正な場合はどのリミッターにも適用しない
+func (w *ConsulSettingsWatcher) apply(pairs api.KVPairs) error {
+	values := map[string]string{}
+	for _, p := range pairs {
+		values[strings.TrimPrefix(p.Key, w.prefix)] = strings.TrimSpace(string(p.Value))
+	}
+
+	settings := make([]Settings, len(w.limiters))
+	for i := range w.limiters {
+		s, err := w.settings(w.defaults[i], values)
+		if err != nil {
+			return err
+		}
+		settings[i] = s
+	}
+	var errs []error
+	for i, l := range w.limiters {
+		if err := l.UpdateSettings(settings[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (w *ConsulSettingsWatcher) settings(s Settings, values map[string]string) (Settings, error) {
+	if v, ok := values[ConsulReqLimitKey]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return s, fmt.Errorf("invalid %s: %w", ConsulReqLimitKey, err)
+		}
+		s.ReqLimit = n
+	}
+	if v, ok := values[ConsulWindowLenKey]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return s, fmt.Errorf("invalid %s: %w", ConsulWindowLenKey, err)
+		}
+		s.WindowLen = d
+	}
+	for _, l := range []struct {
+		key string
+		v   *[]string
+	}{
+		{ConsulTargetExtensionsKey, &s.TargetExtensions},
+		{ConsulTargetMethodsKey, &s.TargetMethods},
+		{ConsulIgnorePathContainsKey, &s.IgnorePathContains},
+		{ConsulIgnorePathPrefixesKey, &s.IgnorePathPrefixes},
+		{ConsulIgnorePathSuffixesKey, &s.IgnorePathSuffixes},
+	} {
+		if v, ok := values[l.key]; ok {
+			*l.v = splitList(v)
+		}
+	}
+	if err := s.validate(); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// カンマまたは改行区切りの値を分割する
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
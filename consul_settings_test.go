@@ -0,0 +1,202 @@
+package rlutils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConsulKVList はブロッキングクエリに対応したConsul KVのListをメモリ上で再現する
+type fakeConsulKVList struct {
+	mu      sync.Mutex
+	index   uint64
+	pairs   map[string]string
+	err     error
+	changed chan struct{}
+}
+
+func newFakeConsulKVList() *fakeConsulKVList {
+	return &fakeConsulKVList{
+		index:   1,
+		pairs:   map[string]string{},
+		changed: make(chan struct{}),
+	}
+}
+
+func (f *fakeConsulKVList) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	for {
+		f.mu.Lock()
+		if f.err != nil {
+			err := f.err
+			f.mu.Unlock()
+			return nil, nil, err
+		}
+		if q.WaitIndex < f.index {
+			var pairs api.KVPairs
+			for k, v := range f.pairs {
+				if strings.HasPrefix(k, prefix) {
+					pairs = append(pairs, &api.KVPair{Key: k, Value: []byte(v)})
+				}
+			}
+			index := f.index
+			f.mu.Unlock()
+			return pairs, &api.QueryMeta{LastIndex: index}, nil
+		}
+		ch := f.changed
+		f.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-q.Context().Done():
+			return nil, nil, q.Context().Err()
+		}
+	}
+}
+
+func (f *fakeConsulKVList) update(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsulKVList) put(key, value string) {
+	f.update(func() { f.pairs[key] = value })
+}
+
+func (f *fakeConsulKVList) setErr(err error) {
+	f.update(func() { f.err = err })
+}
+
+func TestConsulSettingsWatcherLoad(t *testing.T) {
+	kv := newFakeConsulKVList()
+	kv.put("rlutils/ip/req_limit", "10")
+	kv.put("rlutils/ip/window_len", "30s")
+	kv.put("rlutils/ip/target_methods", "GET, POST")
+	kv.put("rlutils/ip/ignore_path_prefixes", "/health\n/metrics")
+	kv.put("rlutils/host/req_limit", "1")
+
+	limiter := NewIPLimiter(5, time.Minute, nil, TargetExtensions([]string{"html"}))
+	assert.NoError(t, limiter.Err())
+	w := newConsulSettingsWatcher(kv, "rlutils/ip", []SettingsUpdater{limiter})
+	assert.NoError(t, w.Load(context.Background()))
+
+	assert.Equal(t, Settings{
+		ReqLimit:           10,
+		WindowLen:          30 * time.Second,
+		TargetExtensions:   []string{".html"},
+		TargetMethods:      []string{"GET", "POST"},
+		IgnorePathPrefixes: []string{"/health", "/metrics"},
+	}, limiter.Settings())
+	assert.False(t, limiter.isTargetMethod(testHTTPRequestWithMethod("DELETE")))
+}
+
+func TestConsulSettingsWatcherInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{name: "req_limit is not a number", key: "req_limit", value: "ten"},
+		{name: "req_limit is negative", key: "req_limit", value: "-1"},
+		{name: "window_len is not a duration", key: "window_len", value: "1"},
+		{name: "window_len is zero", key: "window_len", value: "0s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := newFakeConsulKVList()
+			kv.put("rlutils/ip/req_limit", "10")
+			kv.put("rlutils/ip/"+tt.key, tt.value)
+
+			ipLimiter := NewIPLimiter(5, time.Minute, nil)
+			assert.NoError(t, ipLimiter.Err())
+			hostLimiter := NewHostLimiter(5, time.Minute, nil)
+			assert.NoError(t, hostLimiter.Err())
+			w := newConsulSettingsWatcher(kv, "rlutils/ip", []SettingsUpdater{ipLimiter, hostLimiter})
+			assert.Error(t, w.Load(context.Background()))
+
+			for _, l := range []SettingsUpdater{ipLimiter, hostLimiter} {
+				assert.Equal(t, 5, l.Settings().ReqLimit)
+				assert.Equal(t, time.Minute, l.Settings().WindowLen)
+			}
+		})
+	}
+}
+
+func TestConsulSettingsWatcherRun(t *testing.T) {
+	kv := newFakeConsulKVList()
+	kv.put("rlutils/ip/req_limit", "10")
+
+	limiter := NewIPLimiter(5, time.Minute, nil)
+	assert.NoError(t, limiter.Err())
+
+	var mu sync.Mutex
+	var errs []error
+	w := newConsulSettingsWatcher(
+		kv,
+		"rlutils/ip/",
+		[]SettingsUpdater{limiter},
+		ConsulSettingsRetryInterval(time.Millisecond),
+		OnConsulSettingsError(func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	assert.Eventually(t, func() bool { return limiter.Settings().ReqLimit == 10 }, time.Second, time.Millisecond)
+
+	kv.put("rlutils/ip/req_limit", "20")
+	assert.Eventually(t, func() bool { return limiter.Settings().ReqLimit == 20 }, time.Second, time.Millisecond)
+
+	// 不正な値は適用されず、直前の設定が使われ続ける
+	kv.put("rlutils/ip/req_limit", "twenty")
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(errs) > 0
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 20, limiter.Settings().ReqLimit)
+
+	// Consulに接続できない間も直前の設定が使われ続ける
+	kv.setErr(errors.New("connection refused"))
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(errs) > 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 20, limiter.Settings().ReqLimit)
+
+	// 復旧後に最新の設定が適用され、削除されたキーは作成時の設定に戻る
+	kv.update(func() {
+		kv.err = nil
+		kv.pairs["rlutils/ip/window_len"] = "10s"
+		delete(kv.pairs, "rlutils/ip/req_limit")
+	})
+	assert.Eventually(t, func() bool {
+		s := limiter.Settings()
+		return s.ReqLimit == 5 && s.WindowLen == 10*time.Second
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func testHTTPRequestWithMethod(method string) *http.Request {
+	request, _ := http.NewRequest(method, "http://example.com", nil)
+	return request
+}
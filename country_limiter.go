@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/2manymws/rl"
@@ -14,10 +14,12 @@ import (
 )
 
 type CountryLimiter struct {
+	mu            sync.RWMutex // 検索中にdbを閉じない
 	db            *maxminddb.Reader
+	closed        bool
 	countries     map[string]struct{}
 	skipCountries map[string]struct{}
-	BaseLimiter
+	Limiter
 }
 type key int
 
@@ -47,49 +49,50 @@ func NewCountryLimiter(
 
 	for _, c := range skipCountries {
 		if c == "*" {
+			db.Close()
 			return nil, fmt.Errorf("invalid skip country: %s", c)
 		}
 		scm[c] = struct{}{}
 	}
+	base, err := NewLimiter(
+		"country_limiter",
+		nil,
+		RemoteAddrKeyFunc(),
+		reqLimit,
+		windowLen,
+		onRequestLimit,
+		setter...,
+	)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 	return &CountryLimiter{
 		db:            db,
 		countries:     cm,
 		skipCountries: scm,
-		BaseLimiter: NewBaseLimiter(
-			reqLimit,
-			windowLen,
-			onRequestLimit,
-			setter...,
-		),
+		Limiter:       *base,
 	}, nil
 }
 
-func (l *CountryLimiter) Name() string {
-	return "country_limiter"
-}
-
 func (l *CountryLimiter) Rule(r *http.Request) (*rl.Rule, error) {
-	if !l.IsTargetRequest(r) {
+	s := l.settings.Load()
+	if !l.isTargetRequest(s, r) {
 		return &rl.Rule{ReqLimit: -1}, nil
 	}
 
-	remoteAddr := strings.Split(r.RemoteAddr, ":")[0]
+	addr := l.remoteAddr(r)
 	country := ""
 	if r.Context().Value(ContextCountryKey) != nil {
 		country = r.Context().Value(ContextCountryKey).(string)
 	} else {
-		c, err := l.country(remoteAddr)
+		c, err := l.country(addr)
 		if err != nil {
 			return nil, err
 		}
 		country = c
 	}
 
-	limit := &rl.Rule{
-		Key:       remoteAddr,
-		ReqLimit:  l.reqLimit,
-		WindowLen: l.windowLen,
-	}
 	noLimit := &rl.Rule{ReqLimit: -1}
 
 	if country == "" {
@@ -101,16 +104,11 @@ func (l *CountryLimiter) Rule(r *http.Request) (*rl.Rule, error) {
 	}
 
 	if _, ok := l.countries["*"]; ok {
-		return limit, nil
-
+		return l.newRule(l.namespacedKey(addr), s.ReqLimit, s.WindowLen), nil
 	}
 
 	if _, ok := l.countries[country]; ok {
-		return &rl.Rule{
-			Key:       remoteAddr,
-			ReqLimit:  l.reqLimit,
-			WindowLen: l.windowLen,
-		}, nil
+		return l.newRule(l.namespacedKey(addr), s.ReqLimit, s.WindowLen), nil
 	}
 	return noLimit, nil
 }
@@ -122,6 +120,11 @@ func (l *CountryLimiter) country(remoteAddr string) (string, error) {
 		} `maxminddb:"country"`
 	}
 
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return "", nil
+	}
 	err := l.db.Lookup(net.ParseIP(remoteAddr), &record)
 	if err != nil {
 		return "", err
@@ -130,6 +133,15 @@ func (l *CountryLimiter) country(remoteAddr string) (string, error) {
 	return record.Country.ISOCode, nil
 }
 
-func (l *CountryLimiter) OnRequestLimit(r *rl.Context) http.HandlerFunc {
-	return l.onRequestLimit(r, l.Name())
+// カウンタのgoroutineを止め、国のデータベースを閉じる
+// 止めた後は国を判別できないので制限しない
+func (l *CountryLimiter) Stop() {
+	l.Limiter.Stop()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	l.closed = true
+	l.db.Close()
 }
@@ -0,0 +1,195 @@
+package rlutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+)
+
+const (
+	defaultCrawlerVerifyTTL         = time.Hour
+	defaultCrawlerVerifyFailureTTL  = time.Minute
+	defaultCrawlerVerifyTimeout     = 2 * time.Second
+	defaultCrawlerVerifyCapacity    = 100000
+	defaultCrawlerVerifyConcurrency = 64
+)
+
+// Resolver はクローラーの検証に使うDNSのリゾルバ
+// *net.Resolverはこのインターフェースを満たす
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// CrawlerVerifier は検索エンジンなどのクローラーを名乗るクライアントを、
+// 逆引きと正引きが一致するか(FCrDNS)で検証する
+// 検証結果はアドレスごとにキャッシュし、DNSのエラーで検証できなかった場合も短い間キャッシュする
+// 同じアドレスの検証は1回の問い合わせにまとめ、同時に問い合わせる数を制限する
+type CrawlerVerifier struct {
+	resolver    Resolver
+	timeout     time.Duration
+	failureTTL  time.Duration
+	concurrency int
+	cache       *ttlcache.Cache[string, bool]
+	mu          sync.Mutex
+	calls       map[string]*crawlerVerifyCall
+}
+
+// 問い合わせ中の検証
+type crawlerVerifyCall struct {
+	done chan struct{}
+	ok   bool
+}
+
+// クローラーの検証を作成する
+// resolverがnilの場合はnet.DefaultResolverを使い、ttlが0以下の場合は1時間キャッシュする
+func NewCrawlerVerifier(resolver Resolver, ttl time.Duration) *CrawlerVerifier {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if ttl <= 0 {
+		ttl = defaultCrawlerVerifyTTL
+	}
+	return &CrawlerVerifier{
+		resolver:    resolver,
+		timeout:     defaultCrawlerVerifyTimeout,
+		failureTTL:  min(defaultCrawlerVerifyFailureTTL, ttl),
+		concurrency: defaultCrawlerVerifyConcurrency,
+		calls:       map[string]*crawlerVerifyCall{},
+		cache: ttlcache.New[string, bool](
+			ttlcache.WithTTL[string, bool](ttl),
+			ttlcache.WithCapacity[string, bool](defaultCrawlerVerifyCapacity),
+			ttlcache.WithDisableTouchOnHit[string, bool](),
+		),
+	}
+}
+
+// アドレスの逆引き結果がdomainsのいずれかで終わり、その名前の正引き結果にアドレスが含まれるかを返す
+// DNSの一時的なエラーで検証できなかった場合はfalseを返し、1分間(ttlの方が短い場合はttl)は問い合わせ直さない
+// 問い合わせ中の数が上限に達している場合は、問い合わせずにfalseを返す
+func (v *CrawlerVerifier) Verify(ctx context.Context, addr netip.Addr, domains []string) bool {
+	if !addr.IsValid() || len(domains) == 0 {
+		return false
+	}
+	addr = normalizeAddr(addr)
+	key := addr.String() + " " + strings.Join(domains, ",")
+	if item := v.cache.Get(key); item != nil {
+		return item.Value()
+	}
+	v.mu.Lock()
+	c, ok := v.calls[key]
+	if !ok {
+		if len(v.calls) >= v.concurrency {
+			v.mu.Unlock()
+			return false
+		}
+		c = &crawlerVerifyCall{done: make(chan struct{})}
+		v.calls[key] = c
+		go v.lookup(key, addr, domains, c)
+	}
+	v.mu.Unlock()
+	select {
+	case <-c.done:
+		return c.ok
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// 検証して結果をキャッシュする
+// 待っている他のリクエストのために、リクエストがキャンセルされても問い合わせを続ける
+func (v *CrawlerVerifier) lookup(key string, addr netip.Addr, domains []string, c *crawlerVerifyCall) {
+	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	defer cancel()
+	ok, err := v.verify(ctx, addr, domains)
+	ttl := ttlcache.DefaultTTL
+	if err != nil {
+		ttl = v.failureTTL
+	}
+	v.cache.Set(key, ok, ttl)
+	v.mu.Lock()
+	delete(v.calls, key)
+	v.mu.Unlock()
+	c.ok = ok
+	close(c.done)
+}
+
+func (v *CrawlerVerifier) verify(ctx context.Context, addr netip.Addr, domains []string) (bool, error) {
+	names, err := v.resolver.LookupAddr(ctx, addr.String())
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	var lookupErr error
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if !hasDomainSuffix(name, domains) {
+			continue
+		}
+		network := "ip6"
+		if addr.Is4() {
+			network = "ip4"
+		}
+		addrs, err := v.resolver.LookupNetIP(ctx, network, name)
+		if err != nil {
+			if !isNotFound(err) {
+				lookupErr = err
+			}
+			continue
+		}
+		for _, a := range addrs {
+			if normalizeAddr(a) == addr {
+				return true, nil
+			}
+		}
+	}
+	return false, lookupErr
+}
+
+func hasDomainSuffix(name string, domains []string) bool {
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		if name == d || strings.HasSuffix(name, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// UserAgentLimiterでクローラーを名乗るリクエストをFCrDNSで検証する
+// ボットカタログでVerifyDomainsを持つシグネチャに判別され、検証に失敗したリクエストはimpostorのルールで数える
+// impostorはUserAgentRulesより先に判定するため、なりすましがクローラー向けの上限を使うことはない
+// impostorのPatternとMatchは使われず、Nameを省略した場合はimpostorになる
+func VerifyCrawlers(v *CrawlerVerifier, impostor UserAgentRule) Option {
+	return func(args *Options) {
+		args.limiterOption("VerifyCrawlers")
+		if v == nil {
+			args.errs = append(args.errs, errors.New("crawler verifier is required"))
+			return
+		}
+		if impostor.Name == "" {
+			impostor.Name = "impostor"
+		}
+		if err := (Settings{ReqLimit: impostor.ReqLimit, WindowLen: impostor.WindowLen}).validate(); err != nil {
+			args.errs = append(args.errs, fmt.Errorf("impostor rule %s: %w", impostor.Name, err))
+			return
+		}
+		args.CrawlerVerifier = v
+		args.ImpostorRule = &impostor
+		args.ruleWindow(impostor.WindowLen)
+	}
+}
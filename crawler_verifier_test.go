@@ -0,0 +1,271 @@
+package rlutils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeResolver struct {
+	mu      sync.Mutex
+	ptr     map[string][]string
+	hosts   map[string][]netip.Addr
+	err     error
+	lookups int
+	wait    chan struct{} // 閉じるまで逆引きを待たせる
+}
+
+func (f *fakeResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	if f.wait != nil {
+		<-f.wait
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lookups++
+	if f.err != nil {
+		return nil, f.err
+	}
+	names, ok := f.ptr[addr]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return names, nil
+}
+
+func (f *fakeResolver) LookupNetIP(_ context.Context, network, host string) ([]netip.Addr, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var addrs []netip.Addr
+	for _, a := range f.hosts[host] {
+		if network == "ip4" && !a.Is4() || network == "ip6" && a.Is4() {
+			continue
+		}
+		addrs = append(addrs, a)
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func newFakeResolver() *fakeResolver {
+	return &fakeResolver{
+		ptr: map[string][]string{
+			"66.249.66.1":  {"crawl-66-249-66-1.googlebot.com."},
+			"192.0.2.1":    {"crawl-192-0-2-1.googlebot.com.evil.example."},
+			"192.0.2.2":    {"crawl-192-0-2-2.googlebot.com."},
+			"2001:db8::1":  {"crawl.GoogleBot.com."},
+			"198.51.100.1": {"notgooglebot.com."},
+		},
+		hosts: map[string][]netip.Addr{
+			"crawl-66-249-66-1.googlebot.com":            {netip.MustParseAddr("66.249.66.1")},
+			"crawl-192-0-2-1.googlebot.com.evil.example": {netip.MustParseAddr("192.0.2.1")},
+			"crawl-192-0-2-2.googlebot.com":              {netip.MustParseAddr("66.249.66.2")},
+			"crawl.googlebot.com":                        {netip.MustParseAddr("2001:db8::1")},
+			"notgooglebot.com":                           {netip.MustParseAddr("198.51.100.1")},
+		},
+	}
+}
+
+func TestCrawlerVerifier_Verify(t *testing.T) {
+	domains := []string{"googlebot.com", "google.com"}
+	cases := []struct {
+		name     string
+		addr     string
+		expected bool
+	}{
+		{"Forward-confirmed", "66.249.66.1", true},
+		{"IPv4-mapped IPv6 address", "::ffff:66.249.66.1", true},
+		{"Case-insensitive IPv6", "2001:db8::1", true},
+		{"Domain is not a suffix", "192.0.2.1", false},
+		{"Forward lookup does not match", "192.0.2.2", false},
+		{"Suffix without dot boundary", "198.51.100.1", false},
+		{"No PTR record", "203.0.113.1", false},
+	}
+	v := NewCrawlerVerifier(newFakeResolver(), time.Minute)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := v.Verify(context.Background(), netip.MustParseAddr(tc.addr), domains)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestCrawlerVerifier_Cache(t *testing.T) {
+	domains := []string{"googlebot.com"}
+	resolver := newFakeResolver()
+	v := NewCrawlerVerifier(resolver, time.Minute)
+
+	assert.True(t, v.Verify(context.Background(), netip.MustParseAddr("66.249.66.1"), domains))
+	assert.False(t, v.Verify(context.Background(), netip.MustParseAddr("203.0.113.1"), domains))
+	assert.True(t, v.Verify(context.Background(), netip.MustParseAddr("66.249.66.1"), domains))
+	assert.False(t, v.Verify(context.Background(), netip.MustParseAddr("203.0.113.1"), domains))
+	assert.Equal(t, 2, resolver.lookups)
+
+	// 一時的なエラーは短い間だけキャッシュする
+	v.failureTTL = 50 * time.Millisecond
+	resolver.mu.Lock()
+	resolver.err = errors.New("temporary failure")
+	resolver.mu.Unlock()
+	assert.False(t, v.Verify(context.Background(), netip.MustParseAddr("192.0.2.2"), domains))
+	resolver.mu.Lock()
+	resolver.err = nil
+	resolver.ptr["192.0.2.2"] = []string{"crawl-66-249-66-1.googlebot.com."}
+	resolver.hosts["crawl-66-249-66-1.googlebot.com"] = append(resolver.hosts["crawl-66-249-66-1.googlebot.com"], netip.MustParseAddr("192.0.2.2"))
+	resolver.mu.Unlock()
+	assert.False(t, v.Verify(context.Background(), netip.MustParseAddr("192.0.2.2"), domains))
+	assert.Equal(t, 3, resolver.lookups)
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, v.Verify(context.Background(), netip.MustParseAddr("192.0.2.2"), domains))
+	assert.Equal(t, 4, resolver.lookups)
+}
+
+func TestCrawlerVerifier_Concurrency(t *testing.T) {
+	domains := []string{"googlebot.com"}
+	resolver := newFakeResolver()
+	resolver.wait = make(chan struct{})
+	v := NewCrawlerVerifier(resolver, time.Minute)
+	v.concurrency = 1
+
+	// 同じアドレスの検証は1回の問い合わせを待つ
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.True(t, v.Verify(context.Background(), netip.MustParseAddr("66.249.66.1"), domains))
+		}()
+	}
+	assert.Eventually(t, func() bool {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		return len(v.calls) == 1
+	}, time.Second, time.Millisecond)
+
+	// 問い合わせ中の数が上限に達すると、待たずに検証に失敗する
+	assert.False(t, v.Verify(context.Background(), netip.MustParseAddr("2001:db8::1"), domains))
+	// キャンセルされたリクエストは問い合わせの完了を待たない
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, v.Verify(ctx, netip.MustParseAddr("66.249.66.1"), domains))
+
+	close(resolver.wait)
+	wg.Wait()
+	assert.Equal(t, 1, resolver.lookups)
+	assert.True(t, v.Verify(context.Background(), netip.MustParseAddr("66.249.66.1"), domains))
+	assert.True(t, v.Verify(context.Background(), netip.MustParseAddr("2001:db8::1"), domains))
+	assert.Equal(t, 2, resolver.lookups)
+}
+
+func TestCrawlerVerifier_DefaultGooglebotDomains(t *testing.T) {
+	// GCEのVMは正引きと一致するbc.googleusercontent.comの逆引きを持てる
+	resolver := newFakeResolver()
+	resolver.ptr["203.0.113.7"] = []string{"7.113.0.203.bc.googleusercontent.com."}
+	resolver.hosts["7.113.0.203.bc.googleusercontent.com"] = []netip.Addr{netip.MustParseAddr("203.0.113.7")}
+	resolver.ptr["203.0.113.8"] = []string{"fetcher-1.gae.googleusercontent.com."}
+	resolver.hosts["fetcher-1.gae.googleusercontent.com"] = []netip.Addr{netip.MustParseAddr("203.0.113.8")}
+
+	googlebot, ok := DefaultBotCatalog().Classify("Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)")
+	assert.True(t, ok)
+	v := NewCrawlerVerifier(resolver, time.Minute)
+	assert.False(t, v.Verify(context.Background(), netip.MustParseAddr("203.0.113.7"), googlebot.VerifyDomains))
+	assert.True(t, v.Verify(context.Background(), netip.MustParseAddr("203.0.113.8"), googlebot.VerifyDomains))
+	assert.True(t, v.Verify(context.Background(), netip.MustParseAddr("66.249.66.1"), googlebot.VerifyDomains))
+}
+
+func TestUserAgentLimiter_VerifyCrawlers(t *testing.T) {
+	rules := []UserAgentRule{
+		{Name: "search", Match: UserAgentFamily, Pattern: string(BotFamilySearchEngine), ReqLimit: 100, WindowLen: time.Minute},
+	}
+	impostor := UserAgentRule{ReqLimit: 1, WindowLen: time.Hour, KeyByClientIP: true}
+	cases := []struct {
+		name             string
+		userAgent        string
+		remoteAddr       string
+		rules            []UserAgentRule
+		expectedRule     string
+		expectedKey      string
+		expectedReqLimit int
+	}{
+		{
+			name:             "Verified crawler uses the family rule",
+			userAgent:        "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			remoteAddr:       "66.249.66.1:1234",
+			rules:            rules,
+			expectedRule:     "search",
+			expectedKey:      "user_agent_limiter:search",
+			expectedReqLimit: 100,
+		},
+		{
+			name:             "Impostor uses the impostor rule",
+			userAgent:        "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			remoteAddr:       "203.0.113.1:1234",
+			rules:            rules,
+			expectedRule:     "impostor",
+			expectedKey:      "user_agent_limiter:impostor+203.0.113.1",
+			expectedReqLimit: 1,
+		},
+		{
+			name:             "Impostor without user agent rules",
+			userAgent:        "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			remoteAddr:       "192.0.2.2:1234",
+			expectedRule:     "impostor",
+			expectedKey:      "user_agent_limiter:impostor+192.0.2.2",
+			expectedReqLimit: 1,
+		},
+		{
+			name:             "Crawler without verify domains is not verified",
+			userAgent:        "DuckDuckBot/1.1",
+			remoteAddr:       "203.0.113.1:1234",
+			rules:            rules,
+			expectedRule:     "search",
+			expectedKey:      "user_agent_limiter:search",
+			expectedReqLimit: 100,
+		},
+		{
+			name:             "Verified crawler falls back to user agents",
+			userAgent:        "Googlebot/2.1",
+			remoteAddr:       "66.249.66.1:1234",
+			expectedKey:      "user_agent_limiter:Googlebot",
+			expectedReqLimit: 10,
+		},
+		{
+			name:             "Not a crawler",
+			userAgent:        "Mozilla/5.0",
+			remoteAddr:       "203.0.113.1:1234",
+			expectedReqLimit: -1,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			v := NewCrawlerVerifier(newFakeResolver(), time.Minute)
+			limiter := NewUserAgentLimiter([]string{"Googlebot"}, 10, time.Minute, nil, UserAgentRules(tc.rules), VerifyCrawlers(v, impostor))
+			assert.NoError(t, limiter.Err())
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("User-Agent", tc.userAgent)
+			req.RemoteAddr = tc.remoteAddr
+
+			rule, err := limiter.Rule(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedKey, rule.Key)
+			assert.Equal(t, tc.expectedReqLimit, rule.ReqLimit)
+
+			matched, ok := limiter.MatchedUserAgentRule(req)
+			assert.Equal(t, tc.expectedRule != "", ok)
+			assert.Equal(t, tc.expectedRule, matched.Name)
+		})
+	}
+}
+
+func TestVerifyCrawlers_Invalid(t *testing.T) {
+	assert.Error(t, NewUserAgentLimiter([]string{"Googlebot"}, 10, time.Minute, nil, VerifyCrawlers(nil, UserAgentRule{ReqLimit: 1, WindowLen: time.Hour})).Err())
+	assert.Error(t, NewUserAgentLimiter([]string{"Googlebot"}, 10, time.Minute, nil, VerifyCrawlers(NewCrawlerVerifier(newFakeResolver(), 0), UserAgentRule{ReqLimit: 1})).Err())
+}
@@ -0,0 +1,116 @@
+package rlutils
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/2manymws/rl"
+)
+
+// 拒否するリクエストのルールに使うウィンドウの長さ
+// カウンタの値に関わらず拒否するので、長さに意味はない
+const denyListWindowLen = time.Second
+
+type DenyListLimiter struct {
+	denyCIDRs *prefixSet
+	Limiter
+}
+
+var _ rl.Limiter = (*DenyListLimiter)(nil)
+
+// 拒否リストに含まれるクライアントからのリクエストを、カウンタに関わらず最初のリクエストから拒否する
+// 制限単位はIP
+func NewDenyListLimiter(
+	cidrs []string,
+	onRequestLimit func(*rl.Context, string) http.HandlerFunc,
+	setter ...Option,
+) (*DenyListLimiter, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		p, err := parsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny CIDR: %s: %w", cidr, err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	l, err := NewLimiter(
+		"deny_list_limiter",
+		nil,
+		RemoteAddrKeyFunc(),
+		0,
+		denyListWindowLen,
+		onRequestLimit,
+		setter...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &DenyListLimiter{
+		denyCIDRs: newPrefixSet(prefixes),
+		Limiter:   *l,
+	}, nil
+}
+
+// ファイルから読み込んだ拒否リストでリクエストを拒否する
+// ファイルには1行に1つずつCIDRまたはアドレスを記述する。#以降はコメントとして扱う
+func NewDenyListLimiterFromFile(
+	path string,
+	onRequestLimit func(*rl.Context, string) http.HandlerFunc,
+	setter ...Option,
+) (*DenyListLimiter, error) {
+	cidrs, err := readCIDRList(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewDenyListLimiter(cidrs, onRequestLimit, setter...)
+}
+
+func readCIDRList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cidrs []string
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if _, err := parsePrefix(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid CIDR: %s: %w", path, n, line, err)
+		}
+		cidrs = append(cidrs, line)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return cidrs, nil
+}
+
+// 拒否リストに含まれる場合は上限0のルールを返し、後続のリミッターは評価しない
+func (l *DenyListLimiter) Rule(r *http.Request) (*rl.Rule, error) {
+	s := l.settings.Load()
+	if !l.isTargetRequest(s, r) {
+		return &rl.Rule{ReqLimit: -1}, nil
+	}
+	addr := l.remoteAddr(r)
+	a, err := netip.ParseAddr(addr)
+	if err != nil || !l.denyCIDRs.contains(a) {
+		return &rl.Rule{ReqLimit: -1}, nil
+	}
+	return &rl.Rule{
+		Key:         l.namespacedKey(addr),
+		ReqLimit:    0,
+		WindowLen:   s.WindowLen,
+		IgnoreAfter: true,
+	}, nil
+}
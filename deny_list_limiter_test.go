@@ -0,0 +1,138 @@
+package rlutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/2manymws/rl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDenyListLimiter(t *testing.T) {
+	cases := []struct {
+		name                string
+		remoteAddr          string
+		xff                 string
+		options             []Option
+		expectedToBeLimited bool
+		expectedKey         string
+	}{
+		{
+			name:                "Denied network",
+			remoteAddr:          "198.51.100.10:1234",
+			expectedToBeLimited: true,
+			expectedKey:         "deny_list_limiter:198.51.100.10",
+		},
+		{
+			name:                "Denied address",
+			remoteAddr:          "203.0.113.7:1234",
+			expectedToBeLimited: true,
+			expectedKey:         "deny_list_limiter:203.0.113.7",
+		},
+		{
+			name:                "Denied IPv6 network",
+			remoteAddr:          "[2001:db8:bad::1]:1234",
+			expectedToBeLimited: true,
+			expectedKey:         "deny_list_limiter:2001:db8:bad::1",
+		},
+		{
+			name:                "Not denied",
+			remoteAddr:          "203.0.113.8:1234",
+			expectedToBeLimited: false,
+		},
+		{
+			name:                "Denied client behind trusted proxy",
+			remoteAddr:          "10.0.0.1:1234",
+			xff:                 "198.51.100.10",
+			options:             []Option{TrustedProxies([]string{"10.0.0.0/8"}), ForwardedHeader("X-Forwarded-For")},
+			expectedToBeLimited: true,
+			expectedKey:         "deny_list_limiter:198.51.100.10",
+		},
+		{
+			name:                "Allowed client",
+			remoteAddr:          "198.51.100.10:1234",
+			options:             []Option{AllowCIDRs([]string{"198.51.100.10"})},
+			expectedToBeLimited: false,
+		},
+		{
+			name:                "Invalid remote address",
+			remoteAddr:          "invalid",
+			expectedToBeLimited: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter, err := NewDenyListLimiterFromFile("testdata/deny_list.txt", nil, tc.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.xff != "" {
+				req.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			rule, err := limiter.Rule(req)
+			assert.NoError(t, err)
+			if tc.expectedToBeLimited {
+				assert.Equal(t, 0, rule.ReqLimit)
+				assert.True(t, rule.IgnoreAfter)
+				assert.Equal(t, tc.expectedKey, rule.Key)
+			} else {
+				assert.Equal(t, -1, rule.ReqLimit)
+			}
+		})
+	}
+}
+
+func TestDenyListLimiterRejectsFirstRequest(t *testing.T) {
+	limited := 0
+	deny, err := NewDenyListLimiter([]string{"198.51.100.0/24"}, func(_ *rl.Context, _ string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			limited++
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := NewIPLimiter(100, 0, nil)
+	if err := ip.Err(); err != nil {
+		t.Fatal(err)
+	}
+	h := rl.New(deny, ip)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+
+	for _, tc := range []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"198.51.100.1:1234", http.StatusForbidden},
+		{"192.0.2.1:1234", http.StatusOK},
+		{"198.51.100.1:1234", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, tc.want, rec.Code, tc.remoteAddr)
+	}
+	assert.Equal(t, 2, limited)
+}
+
+func TestNewDenyListLimiterError(t *testing.T) {
+	_, err := NewDenyListLimiter([]string{"198.51.100.0/33"}, nil)
+	assert.Error(t, err)
+
+	_, err = NewDenyListLimiterFromFile("testdata/not_found.txt", nil)
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "deny_list.txt")
+	if err := os.WriteFile(path, []byte("198.51.100.0/24\nbad-host\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewDenyListLimiterFromFile(path, nil)
+	assert.ErrorContains(t, err, "deny_list.txt:2")
+}
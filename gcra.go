@@ -0,0 +1,149 @@
+package rlutils
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/2manymws/rl"
+	"github.com/jellydator/ttlcache/v3"
+)
+
+// GCRACounter はGCRA(Generic Cell Rate Algorithm)でリクエストを制限するカウンタ
+// キーごとに理論上の到着時刻(TAT)だけを保持し、ルールの上限をウィンドウの長さで割った間隔でリクエストを受け付ける
+// burstを指定すると、間隔を空けずに受け付けるリクエスト数の上限になる
+type GCRACounter struct {
+	burst int
+	cache *ttlcache.Cache[string, *gcraState]
+	stop  func()
+	now   func() time.Time
+}
+
+var _ ruleCounter = (*GCRACounter)(nil)
+
+type gcraState struct {
+	mu        sync.Mutex
+	interval  time.Duration // リクエスト1回分の間隔
+	burst     int
+	windowLen time.Duration
+	tat       time.Time
+}
+
+// GCRAのカウンタを作成する
+// burstが0の場合はルールの上限と同じ数まで続けて受け付け、1の場合は常に間隔を空けることを求める
+func NewGCRACounter(burst int) *GCRACounter {
+	cache := ttlcache.New[string, *gcraState]()
+	go cache.Start()
+	return &GCRACounter{
+		burst: burst,
+		cache: cache,
+		stop:  sync.OnceFunc(cache.Stop),
+		now:   time.Now,
+	}
+}
+
+// 期限切れのキーを削除するgoroutineを止める
+func (c *GCRACounter) Stop() {
+	c.stop()
+}
+
+// リミッターのカウンタにGCRAを使う
+// ログインや決済のように、ウィンドウによる近似ではなく正確に間隔を空けさせたい場合に使う
+func GCRA(burst int) Option {
+	return func(args *Options) {
+		if burst < 0 {
+			args.errs = append(args.errs, fmt.Errorf("invalid burst: %d", burst))
+			return
+		}
+		lazyCounter(func() rl.Counter { return NewGCRACounter(burst) })(args)
+	}
+}
+
+// キーに間隔とバーストを設定し、rlに渡す上限としてバーストを返す
+func (c *GCRACounter) configure(key string, reqLimit int, windowLen time.Duration) int {
+	burst := c.burst
+	if burst == 0 {
+		burst = reqLimit
+	}
+	interval := max(windowLen/time.Duration(reqLimit), 1)
+	// TATを過ぎた状態は初期状態と同じなので、バースト分の時間が過ぎると削除する
+	ttl := interval*time.Duration(burst) + windowLen
+	item, loaded := c.cache.GetOrSet(key, &gcraState{
+		interval:  interval,
+		burst:     burst,
+		windowLen: windowLen,
+	}, ttlcache.WithTTL[string, *gcraState](ttl))
+	if loaded {
+		s := item.Value()
+		s.mu.Lock()
+		s.interval, s.burst, s.windowLen = interval, burst, windowLen
+		s.mu.Unlock()
+	}
+	return burst
+}
+
+// TATまでに残っているリクエスト数を返す
+// バースト以上になるとrlが制限する
+func (c *GCRACounter) Get(key string, window time.Time) (int, error) { //nostyle:getters
+	item := c.cache.Get(key)
+	if item == nil {
+		return 0, nil
+	}
+	s := item.Value()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := c.now()
+	if isPreviousWindow(window, now, s.windowLen) {
+		return 0, nil
+	}
+	return s.pending(now), nil
+}
+
+func (c *GCRACounter) Increment(key string, _ time.Time) error {
+	item := c.cache.Get(key)
+	if item == nil {
+		return nil
+	}
+	s := item.Value()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := c.now()
+	tat := s.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	// 他のリミッターで制限された場合も呼ばれるため、TATはバースト分より先に進めない
+	s.tat = minTime(tat.Add(s.interval), now.Add(s.interval*time.Duration(s.burst)))
+	return nil
+}
+
+// TATがバーストの許容範囲に戻るまでの秒数を返す
+func (c *GCRACounter) retryAfter(key string) (int, bool) {
+	item := c.cache.Get(key)
+	if item == nil {
+		return 0, false
+	}
+	s := item.Value()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 許容範囲はburst-1回分の間隔
+	allowAt := s.tat.Add(-s.interval * time.Duration(s.burst-1))
+	d := allowAt.Sub(c.now())
+	return max(int(math.Ceil(d.Seconds())), 1), true
+}
+
+func (s *gcraState) pending(now time.Time) int {
+	d := s.tat.Sub(now)
+	if d <= 0 {
+		return 0
+	}
+	return min(int((d+s.interval-1)/s.interval), s.burst)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
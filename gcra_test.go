@@ -0,0 +1,96 @@
+package rlutils
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/2manymws/rl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCRACounter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewGCRACounter(1)
+	c.now = func() time.Time { return now }
+	window := time.Minute
+
+	// 1分に6回なので10秒間隔
+	assert.Equal(t, 1, c.configure("key", 6, window))
+	count, err := c.Get("key", now.Truncate(window))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.NoError(t, c.Increment("key", now.Truncate(window)))
+
+	count, _ = c.Get("key", now.Truncate(window))
+	assert.Equal(t, 1, count)
+	retryAfter, ok := c.retryAfter("key")
+	assert.True(t, ok)
+	assert.Equal(t, 10, retryAfter)
+
+	now = now.Add(9 * time.Second)
+	count, _ = c.Get("key", now.Truncate(window))
+	assert.Equal(t, 1, count)
+	retryAfter, _ = c.retryAfter("key")
+	assert.Equal(t, 1, retryAfter)
+
+	now = now.Add(time.Second)
+	count, _ = c.Get("key", now.Truncate(window))
+	assert.Equal(t, 0, count)
+
+	// 直前のウィンドウ
+	count, _ = c.Get("key", now.Truncate(window).Add(-window))
+	assert.Equal(t, 0, count)
+}
+
+func TestGCRACounter_Burst(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewGCRACounter(0)
+	c.now = func() time.Time { return now }
+	window := time.Minute
+
+	assert.Equal(t, 3, c.configure("key", 3, window))
+	for i := 0; i < 3; i++ {
+		count, _ := c.Get("key", now.Truncate(window))
+		assert.Equal(t, i, count)
+		assert.NoError(t, c.Increment("key", now.Truncate(window)))
+	}
+	count, _ := c.Get("key", now.Truncate(window))
+	assert.Equal(t, 3, count)
+	// 最初の1回分の間隔が過ぎると次を受け付ける
+	retryAfter, _ := c.retryAfter("key")
+	assert.Equal(t, 20, retryAfter)
+
+	// 他のリミッターで制限された場合でもTATはバースト分より先に進まない
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, c.Increment("key", now.Truncate(window)))
+	}
+	retryAfter, _ = c.retryAfter("key")
+	assert.Equal(t, 20, retryAfter)
+	now = now.Add(20 * time.Second)
+	count, _ = c.Get("key", now.Truncate(window))
+	assert.Equal(t, 2, count)
+}
+
+func TestGCRA_Limiter(t *testing.T) {
+	limiter := NewIPLimiter(6, time.Minute, func(_ *rl.Context, _ string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}, GCRA(1), ResponseHeaders(HeaderModeXRateLimit))
+	assert.NoError(t, limiter.Err())
+
+	h := New(limiter)(okHandler())
+	rec := serveHeaderTest(h)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serveHeaderTest(h)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	assert.NoError(t, err)
+	assert.InDelta(t, 10, retryAfter, 1)
+}
+
+func TestGCRA_Invalid(t *testing.T) {
+	assert.Error(t, NewIPLimiter(6, time.Minute, nil, GCRA(-1)).Err())
+}
@@ -2,6 +2,7 @@ package rlutils
 
 import (
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/2manymws/rl"
@@ -9,12 +10,14 @@ import (
 
 type GetParameterLimiter struct {
 	getParameters map[string]string
-	key           string
-	BaseLimiter
+	rules         []*ParameterRule
+	subjectKey    KeyFunc
+	Limiter
 }
 
 // Getパラメーターごとにリクエスト数を制限する
 // 制限単位はホスト名とGetパラメーター
+// ParameterRulesでルールを指定した場合は、getParametersより先に指定した順に判定する
 func NewGetParameterLimiter(
 	getParameters map[string]string,
 	reqLimit int,
@@ -23,43 +26,46 @@ func NewGetParameterLimiter(
 	onRequestLimit func(*rl.Context, string) http.HandlerFunc,
 	setter ...Option,
 ) (*GetParameterLimiter, error) {
-	err := validateKey(key)
+	l := &GetParameterLimiter{
+		getParameters: getParameters,
+	}
+	base, err := NewLimiter(
+		"get_parameter_limiter",
+		nil,
+		l.getParameterKey,
+		reqLimit,
+		windowLen,
+		onRequestLimit,
+		append([]Option{acceptOptions("ParameterRules")}, setter...)...,
+	)
 	if err != nil {
 		return nil, err
 	}
-	return &GetParameterLimiter{
-		getParameters: getParameters,
-		key:           key,
-		BaseLimiter: NewBaseLimiter(
-			reqLimit,
-			windowLen,
-			onRequestLimit,
-			setter...,
-		),
-	}, nil
-}
-
-func (l *GetParameterLimiter) Name() string {
-	return "get_parameter_limiter"
-}
-
-func (l *GetParameterLimiter) Rule(r *http.Request) (*rl.Rule, error) {
-	if !l.IsTargetRequest(r) {
-		return &rl.Rule{ReqLimit: -1}, nil
-	}
-	for k, v := range l.getParameters {
-		if r.URL.Query().Get(k) == v {
-			return &rl.Rule{
-				Key:       fillKey(r, l.key) + "/" + k + "=" + v,
-				ReqLimit:  l.reqLimit,
-				WindowLen: l.windowLen,
-			}, nil
+	l.Limiter = *base
+	l.rules = append(slices.Clone(l.parameterRules), exactParameterRules(getParameters)...)
+	l.subjectKey = l.keyBy
+	if l.subjectKey == nil {
+		f, err := ParseKey(key)
+		if err != nil {
+			l.Stop()
+			return nil, err
 		}
+		l.subjectKey = f
 	}
-
-	return &rl.Rule{ReqLimit: -1}, nil
+	return l, nil
 }
 
-func (l *GetParameterLimiter) OnRequestLimit(r *rl.Context) http.HandlerFunc {
-	return l.onRequestLimit(r, l.Name())
+func (l *GetParameterLimiter) getParameterKey(r *http.Request) (string, bool) {
+	if len(l.rules) == 0 {
+		return "", false
+	}
+	params, ok := matchParameterRules(l.rules, r.URL.Query())
+	if !ok {
+		return "", false
+	}
+	key, ok := l.subjectKey(r)
+	if !ok {
+		return "", false
+	}
+	return key + "/" + params, true
 }
@@ -29,7 +29,7 @@ func TestGetParameterLimiter(t *testing.T) {
 			queryString:         "?token=123456",
 			key:                 "host",
 			expectedToBeLimited: true,
-			expectedKey:         "example.com/token=123456",
+			expectedKey:         "get_parameter_limiter:example.com/token=123456",
 		},
 		{
 			name: "Request without matching get parameter should not be limited",
@@ -49,7 +49,7 @@ func TestGetParameterLimiter(t *testing.T) {
 			queryString:         "?token=123456&sessionId=XYZ",
 			key:                 "host",
 			expectedToBeLimited: true,
-			expectedKey:         "example.com/token=123456",
+			expectedKey:         "get_parameter_limiter:example.com/token=123456",
 		},
 		{
 			name: "Request with matching get parameter should be limited with remote ip",
@@ -59,7 +59,7 @@ func TestGetParameterLimiter(t *testing.T) {
 			queryString:         "?token=123456",
 			key:                 "remote_addr",
 			expectedToBeLimited: true,
-			expectedKey:         "127.0.0.1/token=123456",
+			expectedKey:         "get_parameter_limiter:127.0.0.1/token=123456",
 		},
 	}
 
@@ -8,42 +8,29 @@ import (
 )
 
 type HostLimiter struct {
-	BaseLimiter
+	Limiter
 }
 
 // ホストごとにリクエスト数を制限する
 // 制限単位はホスト名
+// オプションが不正な場合はErrでエラーを返し、Ruleもエラーを返す
 func NewHostLimiter(
 	reqLimit int,
 	windowLen time.Duration,
 	onRequestLimit func(*rl.Context, string) http.HandlerFunc,
 	setter ...Option,
 ) *HostLimiter {
-	return &HostLimiter{
-		BaseLimiter: NewBaseLimiter(
-			reqLimit,
-			windowLen,
-			onRequestLimit,
-			setter...,
-		),
+	l, err := NewLimiter(
+		"host_limiter",
+		nil,
+		HostKeyFunc(),
+		reqLimit,
+		windowLen,
+		onRequestLimit,
+		setter...,
+	)
+	if err != nil {
+		return &HostLimiter{Limiter: failedLimiter("host_limiter", err)}
 	}
-}
-
-func (l *HostLimiter) Name() string {
-	return "host_limiter"
-}
-
-func (l *HostLimiter) Rule(r *http.Request) (*rl.Rule, error) {
-	if !l.IsTargetRequest(r) {
-		return &rl.Rule{ReqLimit: -1}, nil
-	}
-	return &rl.Rule{
-		Key:       r.Host,
-		ReqLimit:  l.reqLimit,
-		WindowLen: l.windowLen,
-	}, nil
-}
-
-func (l *HostLimiter) OnRequestLimit(r *rl.Context) http.HandlerFunc {
-	return l.onRequestLimit(r, l.Name())
+	return &HostLimiter{Limiter: *l}
 }
@@ -38,6 +38,7 @@ func TestHostLimiter(t *testing.T) {
 				nil,
 				nil,
 			)
+			assert.NoError(t, limiter.Err())
 
 			// Using the mock counter instead of the real one.
 			limiter.Counter = mockCounter
@@ -48,7 +49,7 @@ func TestHostLimiter(t *testing.T) {
 
 			if tc.expectedToBeLimited {
 				assert.NotNil(t, rule)
-				assert.Equal(t, tc.host, rule.Key)
+				assert.Equal(t, "host_limiter:"+tc.host, rule.Key)
 				assert.Equal(t, reqLimit, rule.ReqLimit)
 				assert.Equal(t, windowLen, rule.WindowLen)
 			} else {
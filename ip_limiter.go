@@ -2,50 +2,35 @@ package rlutils
 
 import (
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/2manymws/rl"
 )
 
 type IPLimiter struct {
-	BaseLimiter
+	Limiter
 }
 
 // ホストごとにリクエスト数を制限する
 // 制限単位はIP
+// オプションが不正な場合はErrでエラーを返し、Ruleもエラーを返す
 func NewIPLimiter(
 	reqLimit int,
 	windowLen time.Duration,
 	onRequestLimit func(*rl.Context, string) http.HandlerFunc,
 	setter ...Option,
 ) *IPLimiter {
-	return &IPLimiter{
-		BaseLimiter: NewBaseLimiter(
-			reqLimit,
-			windowLen,
-			onRequestLimit,
-			setter...,
-		),
+	l, err := NewLimiter(
+		"ip_limiter",
+		nil,
+		RemoteAddrKeyFunc(),
+		reqLimit,
+		windowLen,
+		onRequestLimit,
+		setter...,
+	)
+	if err != nil {
+		return &IPLimiter{Limiter: failedLimiter("ip_limiter", err)}
 	}
-}
-
-func (l *IPLimiter) Name() string {
-	return "ip_limiter"
-}
-
-func (l *IPLimiter) Rule(r *http.Request) (*rl.Rule, error) {
-	if !l.IsTargetRequest(r) {
-		return &rl.Rule{ReqLimit: -1}, nil
-	}
-	remoteAddr := strings.Split(r.RemoteAddr, ":")[0]
-	return &rl.Rule{
-		Key:       remoteAddr,
-		ReqLimit:  l.reqLimit,
-		WindowLen: l.windowLen,
-	}, nil
-}
-
-func (l *IPLimiter) OnRequestLimit(r *rl.Context) http.HandlerFunc {
-	return l.onRequestLimit(r, l.Name())
+	return &IPLimiter{Limiter: *l}
 }
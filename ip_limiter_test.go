@@ -14,6 +14,7 @@ func TestIPLimiter(t *testing.T) {
 	cases := []struct {
 		name                string
 		remoteAddr          string
+		options             []Option
 		expectedToBeLimited bool
 		expectedKey         string
 	}{
@@ -21,13 +22,46 @@ func TestIPLimiter(t *testing.T) {
 			name:                "IP is limited",
 			remoteAddr:          "10.0.0.1",
 			expectedToBeLimited: true,
-			expectedKey:         "10.0.0.1",
+			expectedKey:         "ip_limiter:10.0.0.1",
 		},
 		{
 			name:                "IP With Port is limited",
 			remoteAddr:          "10.0.0.1:12345",
 			expectedToBeLimited: true,
-			expectedKey:         "10.0.0.1",
+			expectedKey:         "ip_limiter:10.0.0.1",
+		},
+		{
+			name:                "IPv6 With Port is limited",
+			remoteAddr:          "[2001:db8::1]:12345",
+			expectedToBeLimited: true,
+			expectedKey:         "ip_limiter:2001:db8::1",
+		},
+		{
+			name:                "IPv4-mapped IPv6 is limited as IPv4",
+			remoteAddr:          "[::ffff:10.0.0.1]:12345",
+			expectedToBeLimited: true,
+			expectedKey:         "ip_limiter:10.0.0.1",
+		},
+		{
+			name:                "IPv4 is aggregated by prefix",
+			remoteAddr:          "10.0.0.1:12345",
+			options:             []Option{IPPrefixLength(24, 56)},
+			expectedToBeLimited: true,
+			expectedKey:         "ip_limiter:10.0.0.0/24",
+		},
+		{
+			name:                "IPv6 is aggregated by prefix",
+			remoteAddr:          "[2001:db8:1:2ff:1:2:3:4]:12345",
+			options:             []Option{IPPrefixLength(24, 56)},
+			expectedToBeLimited: true,
+			expectedKey:         "ip_limiter:2001:db8:1:200::/56",
+		},
+		{
+			name:                "Only IPv6 is aggregated",
+			remoteAddr:          "10.0.0.1:12345",
+			options:             []Option{IPPrefixLength(0, 64)},
+			expectedToBeLimited: true,
+			expectedKey:         "ip_limiter:10.0.0.1",
 		},
 	}
 
@@ -44,8 +78,9 @@ func TestIPLimiter(t *testing.T) {
 				reqLimit,
 				windowLen,
 				nil,
-				nil,
+				tc.options...,
 			)
+			assert.NoError(t, limiter.Err())
 
 			// Using the mock counter instead of the real one.
 			limiter.Counter = mockCounter
@@ -66,3 +101,15 @@ func TestIPLimiter(t *testing.T) {
 		})
 	}
 }
+
+func TestIPPrefixLengthInvalid(t *testing.T) {
+	for _, lens := range [][2]int{{33, 64}, {24, 129}, {-1, 64}} {
+		assert.Error(t, NewIPLimiter(1, time.Minute, nil, IPPrefixLength(lens[0], lens[1])).Err())
+	}
+
+	// 作成に失敗したリミッターは制限せずに通すのではなくエラーを返す
+	limiter := NewIPLimiter(1, time.Minute, nil, IPPrefixLength(33, 64))
+	_, err := limiter.Rule(httptest.NewRequest("GET", "/", nil))
+	assert.Error(t, err)
+	limiter.Stop()
+}
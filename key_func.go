@@ -0,0 +1,141 @@
+package rlutils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	BasicAuthUserKey = "basic_auth_user"
+	HeaderKeyPrefix  = "header:"
+	CookieKeyPrefix  = "cookie:"
+	QueryKeyPrefix   = "query:"
+)
+
+// KeyFunc はリクエストからカウンタのキーを生成する
+// キーを生成できない場合はfalseを返し、そのリクエストは制限の対象外となる
+type KeyFunc func(r *http.Request) (string, bool)
+
+type limiterContextKey struct{}
+
+// リモートアドレスをキーにする
+// リミッターから呼ばれた場合は、TrustedProxiesやIPPrefixLengthの設定に従ってアドレスを求める
+func RemoteAddrKeyFunc() KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		if l, ok := r.Context().Value(limiterContextKey{}).(*BaseLimiter); ok {
+			return l.remoteAddrKey(r), true
+		}
+		return remoteAddr(r), true
+	}
+}
+
+// ホスト名をキーにする
+func HostKeyFunc() KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		return r.Host, true
+	}
+}
+
+// リクエストヘッダーの値をキーにする
+func HeaderKeyFunc(name string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		v := r.Header.Get(name)
+		return v, v != ""
+	}
+}
+
+// Cookieの値をキーにする
+func CookieKeyFunc(name string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		c, err := r.Cookie(name)
+		if err != nil || c.Value == "" {
+			return "", false
+		}
+		return c.Value, true
+	}
+}
+
+// Getパラメーターの値をキーにする
+func QueryKeyFunc(name string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		v := r.URL.Query().Get(name)
+		return v, v != ""
+	}
+}
+
+// Basic認証のユーザー名をキーにする
+func BasicAuthUserKeyFunc() KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		user, _, ok := r.BasicAuth()
+		return user, ok && user != ""
+	}
+}
+
+// 複数のKeyFuncの結果を+で連結してキーにする
+// いずれかのKeyFuncがキーを生成できない場合はfalseを返す
+func CompositeKeyFunc(keyFuncs ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		keys := make([]string, 0, len(keyFuncs))
+		for _, f := range keyFuncs {
+			k, ok := f(r)
+			if !ok {
+				return "", false
+			}
+			keys = append(keys, k)
+		}
+		return strings.Join(keys, "+"), true
+	}
+}
+
+// 文字列で指定されたキーをKeyFuncに変換する
+// remote_addr, host, basic_auth_user, header:<name>, cookie:<name>, query:<name>を+で連結して指定できる
+func ParseKey(key string) (KeyFunc, error) {
+	parts := strings.Split(key, "+")
+	keyFuncs := make([]KeyFunc, 0, len(parts))
+	for _, part := range parts {
+		f, err := parseKeyPart(part)
+		if err != nil {
+			return nil, err
+		}
+		keyFuncs = append(keyFuncs, f)
+	}
+	if len(keyFuncs) == 1 {
+		return keyFuncs[0], nil
+	}
+	return CompositeKeyFunc(keyFuncs...), nil
+}
+
+func parseKeyPart(key string) (KeyFunc, error) {
+	switch key {
+	case RemoteAddrKey:
+		return RemoteAddrKeyFunc(), nil
+	case HostKey:
+		return HostKeyFunc(), nil
+	case BasicAuthUserKey:
+		return BasicAuthUserKeyFunc(), nil
+	}
+	for _, p := range []struct {
+		prefix string
+		f      func(string) KeyFunc
+	}{
+		{HeaderKeyPrefix, HeaderKeyFunc},
+		{CookieKeyPrefix, CookieKeyFunc},
+		{QueryKeyPrefix, QueryKeyFunc},
+	} {
+		if name, ok := strings.CutPrefix(key, p.prefix); ok && name != "" {
+			return p.f(name), nil
+		}
+	}
+	return nil, fmt.Errorf("invalid key: %s", key)
+}
+
+// KeyFuncを呼び出してカウンタのキーを生成する
+func (l *BaseLimiter) key(r *http.Request, f KeyFunc) (string, bool) {
+	rc := r.WithContext(context.WithValue(r.Context(), limiterContextKey{}, l))
+	key, ok := f(rc)
+	// KeyFuncがボディを読んで差し替えた場合は、後続のハンドラーのために元のリクエストに反映する
+	r.Body = rc.Body
+	return key, ok
+}
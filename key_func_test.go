@@ -0,0 +1,138 @@
+package rlutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		setup   func(r *http.Request)
+		want    string
+		wantOK  bool
+		wantErr bool
+	}{
+		{
+			name:   "remote_addr",
+			key:    "remote_addr",
+			want:   "192.0.2.1",
+			wantOK: true,
+		},
+		{
+			name:   "host",
+			key:    "host",
+			want:   "example.com",
+			wantOK: true,
+		},
+		{
+			name:   "header",
+			key:    "header:X-Api-Key",
+			setup:  func(r *http.Request) { r.Header.Set("X-Api-Key", "secret") },
+			want:   "secret",
+			wantOK: true,
+		},
+		{
+			name:   "missing header",
+			key:    "header:X-Api-Key",
+			wantOK: false,
+		},
+		{
+			name:   "cookie",
+			key:    "cookie:session",
+			setup:  func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "session", Value: "abc"}) },
+			want:   "abc",
+			wantOK: true,
+		},
+		{
+			name:   "query",
+			key:    "query:token",
+			want:   "123",
+			wantOK: true,
+		},
+		{
+			name:   "basic auth user",
+			key:    "basic_auth_user",
+			setup:  func(r *http.Request) { r.SetBasicAuth("alice", "password") },
+			want:   "alice",
+			wantOK: true,
+		},
+		{
+			name:   "composite",
+			key:    "host+remote_addr",
+			want:   "example.com+192.0.2.1",
+			wantOK: true,
+		},
+		{
+			name:   "composite with missing part",
+			key:    "host+cookie:session",
+			wantOK: false,
+		},
+		{
+			name:    "unknown key",
+			key:     "unknown",
+			wantErr: true,
+		},
+		{
+			name:    "header without name",
+			key:     "header:",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseKey(tt.key)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/?token=123", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			if tt.setup != nil {
+				tt.setup(req)
+			}
+			got, ok := f(req)
+			assert.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestKeyBy(t *testing.T) {
+	limiter, err := NewRequestPathLimiter(
+		nil,
+		[]string{"/api/"},
+		nil,
+		5,
+		time.Minute,
+		"",
+		nil,
+		KeyBy(CompositeKeyFunc(HeaderKeyFunc("X-Api-Key"), RemoteAddrKeyFunc())),
+		TrustedProxies([]string{"10.0.0.0/8"}),
+		ForwardedHeader("X-Forwarded-For"),
+	)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/api/users", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	req.Header.Set("X-Api-Key", "secret")
+	rule, err := limiter.Rule(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "request_path_limiter:secret+192.0.2.1/api/", rule.Key)
+
+	req.Header.Del("X-Api-Key")
+	rule, err = limiter.Rule(req)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, rule.ReqLimit)
+}
@@ -0,0 +1,125 @@
+package rlutils
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/2manymws/rl"
+)
+
+// Limiter はマッチ条件とKeyFuncから組み立てるリミッター
+// 独自のリミッターを作る場合や、各リミッターの実装に利用する
+type Limiter struct {
+	name    string
+	matcher func(r *http.Request) bool
+	keyFunc KeyFunc
+	BaseLimiter
+}
+
+var _ rl.Limiter = (*Limiter)(nil)
+
+// 任意の条件とキーでリクエスト数を制限する
+// matcherがnilの場合は全てのリクエストが対象となり、keyFuncがnilの場合はKeyByで指定したKeyFuncを使う
+func NewLimiter(
+	name string,
+	matcher func(r *http.Request) bool,
+	keyFunc KeyFunc,
+	reqLimit int,
+	windowLen time.Duration,
+	onRequestLimit func(*rl.Context, string) http.HandlerFunc,
+	setter ...Option,
+) (*Limiter, error) {
+	l := &Limiter{
+		name:    name,
+		matcher: matcher,
+		keyFunc: keyFunc,
+		BaseLimiter: NewBaseLimiter(
+			reqLimit,
+			windowLen,
+			onRequestLimit,
+			append([]Option{limiterKind(name)}, setter...)...,
+		),
+	}
+	// 作成に失敗した場合は、作成したカウンタのgoroutineを止める
+	if err := l.Err(); err != nil {
+		l.Stop()
+		return nil, err
+	}
+	if l.keyFunc == nil {
+		l.keyFunc = l.keyBy
+	}
+	if l.keyFunc == nil {
+		l.Stop()
+		return nil, errors.New("key func is required")
+	}
+	if l.limiterName != "" {
+		l.name = l.limiterName
+	}
+	if l.keyNamespace == "" {
+		l.keyNamespace = l.name
+	}
+	return l, nil
+}
+
+func (l *Limiter) Name() string {
+	return l.name
+}
+
+func (l *Limiter) Rule(r *http.Request) (*rl.Rule, error) {
+	// 作成に失敗したリミッターは使えない
+	if l.err != nil {
+		return nil, l.err
+	}
+	s := l.settings.Load()
+	if !l.isTargetRequest(s, r) {
+		return &rl.Rule{ReqLimit: -1}, nil
+	}
+	if l.matcher != nil && !l.matcher(r) {
+		return &rl.Rule{ReqLimit: -1}, nil
+	}
+	key, ok := l.key(r, l.keyFunc)
+	if !ok {
+		return &rl.Rule{ReqLimit: -1}, nil
+	}
+	return l.newRule(l.namespacedKey(key), s.ReqLimit, s.WindowLen), nil
+}
+
+// ruleCounter はルールの上限とウィンドウをキーごとに受け取り、ウィンドウ以外の方法で数えるカウンタ
+// rlは固定ウィンドウの数え方で判定するため、Getは上限に達した時に上限以上の値を返し、直前のウィンドウには0を返す
+type ruleCounter interface {
+	rl.Counter
+	// キーに上限とウィンドウを設定し、rlに渡す上限を返す
+	configure(key string, reqLimit int, windowLen time.Duration) int
+	// 次のリクエストを受け付けられるまでの秒数を返す
+	retryAfter(key string) (int, bool)
+}
+
+// ルールを作成する
+// ruleCounterを使う場合は、キーに上限とウィンドウを設定してrlに渡す上限を置き換える
+func (l *BaseLimiter) newRule(key string, reqLimit int, windowLen time.Duration) *rl.Rule {
+	if c, ok := l.Counter.(ruleCounter); ok && reqLimit > 0 && windowLen > 0 {
+		reqLimit = c.configure(key, reqLimit, windowLen)
+	}
+	return &rl.Rule{
+		Key:       key,
+		ReqLimit:  reqLimit,
+		WindowLen: windowLen,
+	}
+}
+
+// rlが直前のウィンドウの数を求めているかを返す
+// rlが時刻を求めてから呼び出すまでにウィンドウが切り替わった場合も直前のウィンドウとみなし、1回だけ多く受け付ける
+func isPreviousWindow(window, now time.Time, windowLen time.Duration) bool {
+	return window.Before(now.Truncate(windowLen))
+}
+
+func (l *Limiter) OnRequestLimit(r *rl.Context) http.HandlerFunc {
+	return l.withResponseHeaders(r, l.onRequestLimit(r, l.Name()))
+}
+
+// 作成に失敗したリミッターを返す
+// 戻り値でエラーを返さないコンストラクタが、ErrとRuleでエラーを返すために使う
+func failedLimiter(name string, err error) Limiter {
+	return Limiter{name: name, BaseLimiter: BaseLimiter{err: err}}
+}
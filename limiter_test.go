@@ -0,0 +1,146 @@
+package rlutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/2manymws/rl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	cases := []struct {
+		name                string
+		matcher             func(r *http.Request) bool
+		keyFunc             KeyFunc
+		options             []Option
+		path                string
+		header              map[string]string
+		expectedToBeLimited bool
+		expectedKey         string
+	}{
+		{
+			name:                "No matcher limits every request",
+			keyFunc:             HostKeyFunc(),
+			path:                "/",
+			expectedToBeLimited: true,
+			expectedKey:         "custom_limiter:example.com",
+		},
+		{
+			name:                "Matching request is limited",
+			matcher:             func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/api/") },
+			keyFunc:             HeaderKeyFunc("X-Api-Key"),
+			path:                "/api/users",
+			header:              map[string]string{"X-Api-Key": "secret"},
+			expectedToBeLimited: true,
+			expectedKey:         "custom_limiter:secret",
+		},
+		{
+			name:                "Non matching request is not limited",
+			matcher:             func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/api/") },
+			keyFunc:             HeaderKeyFunc("X-Api-Key"),
+			path:                "/about",
+			header:              map[string]string{"X-Api-Key": "secret"},
+			expectedToBeLimited: false,
+		},
+		{
+			name:                "Request without key is not limited",
+			keyFunc:             HeaderKeyFunc("X-Api-Key"),
+			path:                "/",
+			expectedToBeLimited: false,
+		},
+		{
+			name:                "KeyBy is used without keyFunc",
+			options:             []Option{KeyBy(RemoteAddrKeyFunc())},
+			path:                "/",
+			expectedToBeLimited: true,
+			expectedKey:         "custom_limiter:192.0.2.1",
+		},
+		{
+			name:                "KeyNamespace overrides the default namespace",
+			keyFunc:             HostKeyFunc(),
+			options:             []Option{KeyNamespace("shared")},
+			path:                "/",
+			expectedToBeLimited: true,
+			expectedKey:         "shared:example.com",
+		},
+		{
+			name:                "Options are applied",
+			keyFunc:             HostKeyFunc(),
+			options:             []Option{IgnorePathPrefixes([]string{"/health"})},
+			path:                "/health",
+			expectedToBeLimited: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reqLimit := 5
+			windowLen := time.Minute
+
+			limiter, err := NewLimiter(
+				"custom_limiter",
+				tc.matcher,
+				tc.keyFunc,
+				reqLimit,
+				windowLen,
+				nil,
+				tc.options...,
+			)
+			assert.NoError(t, err)
+			assert.Equal(t, "custom_limiter", limiter.Name())
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com"+tc.path, nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			rule, err := limiter.Rule(req)
+			assert.NoError(t, err)
+
+			if tc.expectedToBeLimited {
+				assert.Equal(t, tc.expectedKey, rule.Key)
+				assert.Equal(t, reqLimit, rule.ReqLimit)
+				assert.Equal(t, windowLen, rule.WindowLen)
+			} else {
+				assert.Equal(t, -1, rule.ReqLimit)
+			}
+		})
+	}
+}
+
+func TestLimiterWithoutKeyFunc(t *testing.T) {
+	_, err := NewLimiter("custom_limiter", nil, nil, 5, time.Minute, nil)
+	assert.Error(t, err)
+}
+
+func TestLimiterOnRequestLimit(t *testing.T) {
+	var limitedBy string
+	limiter, err := NewLimiter(
+		"custom_limiter",
+		nil,
+		HostKeyFunc(),
+		1,
+		time.Minute,
+		func(_ *rl.Context, name string) http.HandlerFunc {
+			return func(w http.ResponseWriter, _ *http.Request) {
+				limitedBy = name
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		},
+	)
+	assert.NoError(t, err)
+
+	h := rl.New(limiter)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+		assert.Equal(t, want, rec.Code)
+	}
+	assert.Equal(t, "custom_limiter", limitedBy)
+}
@@ -0,0 +1,148 @@
+package rlutils
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ParameterMatchType はParameterConditionの判定方法
+type ParameterMatchType string
+
+const (
+	// パラメーターが存在すればマッチする
+	ParameterPresent ParameterMatchType = "present"
+	// 値がValueと一致すればマッチする
+	ParameterExact ParameterMatchType = "exact"
+	// 値が正規表現Valueにマッチすればマッチする
+	ParameterRegexp ParameterMatchType = "regexp"
+	// 値がValuesのいずれかと一致すればマッチする
+	ParameterAnyOf ParameterMatchType = "any_of"
+)
+
+// ParameterCondition はパラメーター1つに対する条件
+// 同じ名前のパラメーターが複数ある場合は、いずれかの値が条件を満たせばマッチする
+// BodyParameterLimiterでJSONを判定する場合、Nameには/user/idのようなJSON Pointerも指定できる
+type ParameterCondition struct {
+	Name   string             `yaml:"name"`
+	Match  ParameterMatchType `yaml:"match"`
+	Value  string             `yaml:"value"`
+	Values []string           `yaml:"values"`
+	re     *regexp.Regexp
+}
+
+// ParameterRule は全ての条件を満たすリクエストにマッチするルール
+// キーにはマッチした各パラメーターの名前と値が条件の順に含まれる
+type ParameterRule struct {
+	Name       string               `yaml:"name"`
+	Conditions []ParameterCondition `yaml:"conditions"`
+}
+
+func (p *ParameterRule) compile() error {
+	if len(p.Conditions) == 0 {
+		return fmt.Errorf("parameter rule %s: conditions are required", p.Name)
+	}
+	conditions := make([]ParameterCondition, len(p.Conditions))
+	for i, c := range p.Conditions {
+		if c.Name == "" {
+			return fmt.Errorf("parameter rule %s: conditions[%d]: name is required", p.Name, i)
+		}
+		switch c.Match {
+		case ParameterPresent, ParameterExact:
+		case ParameterRegexp:
+			re, err := regexp.Compile(c.Value)
+			if err != nil {
+				return fmt.Errorf("parameter rule %s: conditions[%d]: %w", p.Name, i, err)
+			}
+			c.re = re
+		case ParameterAnyOf:
+			if len(c.Values) == 0 {
+				return fmt.Errorf("parameter rule %s: conditions[%d]: values are required", p.Name, i)
+			}
+		default:
+			return fmt.Errorf("parameter rule %s: conditions[%d]: invalid match type: %q", p.Name, i, c.Match)
+		}
+		conditions[i] = c
+	}
+	p.Conditions = conditions
+	return nil
+}
+
+// 全ての条件を満たす場合に、キーに使うパラメーターの部分を返す
+func (p *ParameterRule) match(q url.Values) (string, bool) {
+	pairs := make([]string, 0, len(p.Conditions))
+	for _, c := range p.Conditions {
+		v, ok := c.match(q)
+		if !ok {
+			return "", false
+		}
+		pairs = append(pairs, c.Name+"="+v)
+	}
+	return strings.Join(pairs, "&"), true
+}
+
+func (c *ParameterCondition) match(q url.Values) (string, bool) {
+	for _, v := range q[c.Name] {
+		var ok bool
+		switch c.Match {
+		case ParameterPresent:
+			ok = true
+		case ParameterExact:
+			ok = v == c.Value
+		case ParameterRegexp:
+			ok = c.re.MatchString(v)
+		case ParameterAnyOf:
+			ok = slices.Contains(c.Values, v)
+		}
+		if ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// 最初にマッチしたルールのキーに使うパラメーターの部分を返す
+func matchParameterRules(rules []*ParameterRule, q url.Values) (string, bool) {
+	for _, rule := range rules {
+		if params, ok := rule.match(q); ok {
+			return params, true
+		}
+	}
+	return "", false
+}
+
+// GetParameterLimiter, BodyParameterLimiterで判定するルールを指定する
+// ルールは指定した順に判定し、最初にマッチしたルールを使う
+// リミッターの作成時に指定したパラメーターは、これらのルールの後にパラメーター名の順に判定する
+func ParameterRules(rules []ParameterRule) Option {
+	return func(args *Options) {
+		args.limiterOption("ParameterRules")
+		for _, rule := range rules {
+			rule := rule
+			if err := rule.compile(); err != nil {
+				args.errs = append(args.errs, err)
+				continue
+			}
+			args.ParameterRules = append(args.ParameterRules, &rule)
+		}
+	}
+}
+
+// パラメーターのmapを完全一致のルールに変換する
+// mapの順序に依存しないよう、パラメーター名の順に並べる
+func exactParameterRules(parameters map[string]string) []*ParameterRule {
+	names := make([]string, 0, len(parameters))
+	for k := range parameters {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	rules := make([]*ParameterRule, 0, len(names))
+	for _, k := range names {
+		rules = append(rules, &ParameterRule{
+			Conditions: []ParameterCondition{{Name: k, Match: ParameterExact, Value: parameters[k]}},
+		})
+	}
+	return rules
+}
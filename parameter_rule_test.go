@@ -0,0 +1,132 @@
+package rlutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetParameterLimiterParameterRules(t *testing.T) {
+	rules := []ParameterRule{
+		{
+			Name: "search",
+			Conditions: []ParameterCondition{
+				{Name: "q", Match: ParameterPresent},
+				{Name: "sort", Match: ParameterAnyOf, Values: []string{"price", "date"}},
+			},
+		},
+		{
+			Name:       "page",
+			Conditions: []ParameterCondition{{Name: "page", Match: ParameterRegexp, Value: `^[0-9]{3,}$`}},
+		},
+		{
+			Name:       "debug",
+			Conditions: []ParameterCondition{{Name: "debug", Match: ParameterExact, Value: "1"}},
+		},
+	}
+	cases := []struct {
+		name                string
+		getParameters       map[string]string
+		queryString         string
+		expectedToBeLimited bool
+		expectedKey         string
+	}{
+		{
+			name:                "All conditions match",
+			queryString:         "?q=shoes&sort=price",
+			expectedToBeLimited: true,
+			expectedKey:         "get_parameter_limiter:example.com/q=shoes&sort=price",
+		},
+		{
+			name:                "Only one of AND conditions matches",
+			queryString:         "?q=shoes&sort=name",
+			expectedToBeLimited: false,
+		},
+		{
+			name:                "Any of multiple values matches",
+			queryString:         "?q=shoes&sort=name&sort=date",
+			expectedToBeLimited: true,
+			expectedKey:         "get_parameter_limiter:example.com/q=shoes&sort=date",
+		},
+		{
+			name:                "Empty value is present",
+			queryString:         "?q=&sort=date",
+			expectedToBeLimited: true,
+			expectedKey:         "get_parameter_limiter:example.com/q=&sort=date",
+		},
+		{
+			name:                "Regexp matches",
+			queryString:         "?page=1000",
+			expectedToBeLimited: true,
+			expectedKey:         "get_parameter_limiter:example.com/page=1000",
+		},
+		{
+			name:                "Regexp does not match",
+			queryString:         "?page=99",
+			expectedToBeLimited: false,
+		},
+		{
+			name:                "First matching rule wins",
+			queryString:         "?debug=1&page=1000&q=a&sort=price",
+			expectedToBeLimited: true,
+			expectedKey:         "get_parameter_limiter:example.com/q=a&sort=price",
+		},
+		{
+			name:                "Rules are evaluated before get parameters",
+			getParameters:       map[string]string{"page": "1000"},
+			queryString:         "?debug=1&page=1000",
+			expectedToBeLimited: true,
+			expectedKey:         "get_parameter_limiter:example.com/page=1000",
+		},
+		{
+			name:                "Get parameters are evaluated in name order",
+			getParameters:       map[string]string{"z": "1", "b": "1", "m": "1"},
+			queryString:         "?z=1&m=1&b=1",
+			expectedToBeLimited: true,
+			expectedKey:         "get_parameter_limiter:example.com/b=1",
+		},
+		{
+			name:                "Absent parameter does not match empty exact value",
+			getParameters:       map[string]string{"token": ""},
+			queryString:         "?other=1",
+			expectedToBeLimited: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter, err := NewGetParameterLimiter(tc.getParameters, 5, time.Minute, "host", nil, ParameterRules(rules))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// mapの順序に依存しないことを確認するため、何度か判定する
+			for i := 0; i < 10; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/"+tc.queryString, nil)
+				rule, err := limiter.Rule(req)
+				assert.NoError(t, err)
+				if tc.expectedToBeLimited {
+					assert.Equal(t, tc.expectedKey, rule.Key)
+					assert.Equal(t, 5, rule.ReqLimit)
+				} else {
+					assert.Equal(t, -1, rule.ReqLimit)
+				}
+			}
+		})
+	}
+}
+
+func TestParameterRulesInvalid(t *testing.T) {
+	for _, rule := range []ParameterRule{
+		{Name: "empty"},
+		{Conditions: []ParameterCondition{{Match: ParameterPresent}}},
+		{Conditions: []ParameterCondition{{Name: "a", Match: "prefix"}}},
+		{Conditions: []ParameterCondition{{Name: "a", Match: ParameterRegexp, Value: "("}}},
+		{Conditions: []ParameterCondition{{Name: "a", Match: ParameterAnyOf}}},
+	} {
+		_, err := NewGetParameterLimiter(nil, 5, time.Minute, "host", nil, ParameterRules([]ParameterRule{rule}))
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,46 @@
+package rlutils
+
+import "testing"
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob string
+		path string
+		want bool
+	}{
+		{"/assets/**/*.map", "/assets/app.js.map", true},
+		{"/assets/**/*.map", "/assets/js/vendor/app.js.map", true},
+		{"/assets/**/*.map", "/assets/app.js", false},
+		{"/assets/**/*.map", "/static/assets/app.js.map", false},
+		{"/api/*/search", "/api/v1/search", true},
+		{"/api/*/search", "/api/v1/x/search", false},
+		{"/api/**", "/api/v1/x/search", true},
+		{"/files/?.txt", "/files/a.txt", true},
+		{"/files/?.txt", "/files/ab.txt", false},
+		{"/files/?.txt", "/files//.txt", false},
+		{"/v[0-9]/*", "/v2/users", true},
+		{"/v[!0-9]/*", "/v2/users", false},
+		{"/v[!0-9]/*", "/vx/users", true},
+		{`/literal\*`, "/literal*", true},
+		{`/literal\*`, "/literalx", false},
+		{"/a.b", "/axb", false},
+		{"/a+b", "/a+b", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.glob+" "+tt.path, func(t *testing.T) {
+			re, err := globToRegexp(tt.glob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := re.MatchString(tt.path); got != tt.want {
+				t.Errorf("%s (%s) matches %s = %v, want %v", tt.glob, re, tt.path, got, tt.want)
+			}
+		})
+	}
+
+	for _, glob := range []string{"/api/[a-", `/api\`, "/api/[z-a]"} {
+		if _, err := globToRegexp(glob); err == nil {
+			t.Errorf("globToRegexp(%s) should return error", glob)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package rlutils
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PathMatchType はPathRuleのパターンの種類
+type PathMatchType string
+
+const (
+	PathMatchPrefix   PathMatchType = "prefix"
+	PathMatchSuffix   PathMatchType = "suffix"
+	PathMatchContains PathMatchType = "contains"
+	PathMatchRegexp   PathMatchType = "regexp"
+	PathMatchGlob     PathMatchType = "glob"
+	PathMatchTemplate PathMatchType = "template"
+)
+
+// PathRule はRequestPathLimiterでパスごとに上限を変えるためのルール
+// KeyModeがRouteKeyTemplateの場合はルールごとに、RouteKeyValuesの場合は実際のパス(テンプレートの場合はプレースホルダの値)ごとに数える
+// Nameを省略した場合はPatternが使われる
+type PathRule struct {
+	Name      string
+	Match     PathMatchType
+	Pattern   string
+	ReqLimit  int
+	WindowLen time.Duration
+	KeyMode   RouteKeyMode
+	re        *regexp.Regexp
+	template  *RouteTemplate
+}
+
+func (p *PathRule) compile() error {
+	if p.Name == "" {
+		p.Name = p.Pattern
+	}
+	if p.Pattern == "" {
+		return fmt.Errorf("path rule %s: pattern is required", p.Name)
+	}
+	if err := (Settings{ReqLimit: p.ReqLimit, WindowLen: p.WindowLen}).validate(); err != nil {
+		return fmt.Errorf("path rule %s: %w", p.Name, err)
+	}
+	if p.KeyMode != RouteKeyTemplate && p.KeyMode != RouteKeyValues {
+		return fmt.Errorf("path rule %s: invalid key mode: %d", p.Name, p.KeyMode)
+	}
+	switch p.Match {
+	case PathMatchPrefix, PathMatchSuffix, PathMatchContains:
+	case PathMatchRegexp:
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return fmt.Errorf("path rule %s: %w", p.Name, err)
+		}
+		p.re = re
+	case PathMatchGlob:
+		re, err := globToRegexp(p.Pattern)
+		if err != nil {
+			return fmt.Errorf("path rule %s: %w", p.Name, err)
+		}
+		p.re = re
+	case PathMatchTemplate:
+		t, err := ParseRouteTemplate(p.Pattern, p.KeyMode)
+		if err != nil {
+			return fmt.Errorf("path rule %s: %w", p.Name, err)
+		}
+		p.template = t
+	default:
+		return fmt.Errorf("path rule %s: invalid match type: %q", p.Name, p.Match)
+	}
+	return nil
+}
+
+// パスがルールにマッチする場合に、キーに使うパスの部分を返す
+func (p *PathRule) match(path string) (string, bool) {
+	var ok bool
+	switch p.Match {
+	case PathMatchPrefix:
+		ok = strings.HasPrefix(path, p.Pattern)
+	case PathMatchSuffix:
+		ok = strings.HasSuffix(path, p.Pattern)
+	case PathMatchContains:
+		ok = strings.Contains(path, p.Pattern)
+	case PathMatchRegexp, PathMatchGlob:
+		ok = p.re.MatchString(path)
+	case PathMatchTemplate:
+		values, matched := p.template.Match(path)
+		if !matched {
+			return "", false
+		}
+		return p.template.key(values), true
+	}
+	if !ok {
+		return "", false
+	}
+	if p.KeyMode == RouteKeyValues {
+		return path, true
+	}
+	return p.Pattern, true
+}
+
+// RequestPathLimiterでパスごとに上限を変えるルールを指定する
+// ルールは指定した順に判定し、最初にマッチしたルールの上限とウィンドウを使う
+// どのルールにもマッチしない場合は、リミッターのパスの指定と上限で判定する
+func PathRules(rules []PathRule) Option {
+	return func(args *Options) {
+		args.limiterOption("PathRules")
+		names := map[string]struct{}{}
+		for _, rule := range rules {
+			rule := rule
+			if err := rule.compile(); err != nil {
+				args.errs = append(args.errs, err)
+				continue
+			}
+			if _, ok := names[rule.Name]; ok {
+				args.errs = append(args.errs, fmt.Errorf("duplicate path rule: %s", rule.Name))
+				continue
+			}
+			names[rule.Name] = struct{}{}
+			args.PathRules = append(args.PathRules, &rule)
+			args.ruleWindow(rule.WindowLen)
+		}
+	}
+}
+
+// リクエストにマッチするルールを返す
+// OnRequestLimitの中で、どのルールで制限されたかを調べるために使う
+func (l *RequestPathLimiter) MatchedPathRule(r *http.Request) (PathRule, bool) {
+	rule, _, ok := l.matchPathRule(r)
+	if !ok {
+		return PathRule{}, false
+	}
+	return *rule, true
+}
+
+func (l *RequestPathLimiter) matchPathRule(r *http.Request) (*PathRule, string, bool) {
+	for _, rule := range l.pathRules {
+		if path, ok := rule.match(r.URL.Path); ok {
+			return rule, path, true
+		}
+	}
+	return nil, "", false
+}
@@ -0,0 +1,177 @@
+package rlutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/2manymws/rl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestPathLimiterPathRules(t *testing.T) {
+	rules := []PathRule{
+		{Name: "search", Match: PathMatchPrefix, Pattern: "/search", ReqLimit: 10, WindowLen: time.Minute},
+		{Name: "export", Match: PathMatchTemplate, Pattern: "/users/{id}/export", ReqLimit: 1, WindowLen: time.Hour, KeyMode: RouteKeyValues},
+		{Match: PathMatchGlob, Pattern: "/files/**", ReqLimit: 100, WindowLen: time.Second, KeyMode: RouteKeyValues},
+		{Name: "status", Match: PathMatchSuffix, Pattern: "/status", ReqLimit: 1000, WindowLen: time.Minute},
+		{Name: "api", Match: PathMatchRegexp, Pattern: `^/api/v[0-9]+/`, ReqLimit: 50, WindowLen: time.Minute},
+		{Name: "search_again", Match: PathMatchContains, Pattern: "search", ReqLimit: 1, WindowLen: time.Minute},
+	}
+	cases := []struct {
+		name              string
+		path              string
+		expectedRule      string
+		expectedKey       string
+		expectedReqLimit  int
+		expectedWindowLen time.Duration
+	}{
+		{
+			name:              "First matching rule wins",
+			path:              "/search/users",
+			expectedRule:      "search",
+			expectedKey:       "request_path_limiter:example.com/search#search",
+			expectedReqLimit:  10,
+			expectedWindowLen: time.Minute,
+		},
+		{
+			name:              "Template rule keyed by values",
+			path:              "/users/42/export",
+			expectedRule:      "export",
+			expectedKey:       "request_path_limiter:example.com/users/{id}/export:id=42#export",
+			expectedReqLimit:  1,
+			expectedWindowLen: time.Hour,
+		},
+		{
+			name:              "Glob rule keyed by path and named by pattern",
+			path:              "/files/a/b.txt",
+			expectedRule:      "/files/**",
+			expectedKey:       "request_path_limiter:example.com/files/a/b.txt#/files/**",
+			expectedReqLimit:  100,
+			expectedWindowLen: time.Second,
+		},
+		{
+			name:              "Suffix rule",
+			path:              "/api/v1/status",
+			expectedRule:      "status",
+			expectedKey:       "request_path_limiter:example.com/status#status",
+			expectedReqLimit:  1000,
+			expectedWindowLen: time.Minute,
+		},
+		{
+			name:              "Regexp rule",
+			path:              "/api/v1/users",
+			expectedRule:      "api",
+			expectedKey:       "request_path_limiter:example.com^/api/v[0-9]+/#api",
+			expectedReqLimit:  50,
+			expectedWindowLen: time.Minute,
+		},
+		{
+			name:              "Falls back to limiter paths",
+			path:              "/legacy/users",
+			expectedKey:       "request_path_limiter:example.com/legacy/",
+			expectedReqLimit:  5,
+			expectedWindowLen: 2 * time.Minute,
+		},
+		{
+			name:             "No match",
+			path:             "/other",
+			expectedReqLimit: -1,
+		},
+	}
+
+	limiter, err := NewRequestPathLimiter(nil, []string{"/legacy/"}, nil, 5, 2*time.Minute, "host", nil, PathRules(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			rule, err := limiter.Rule(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedKey, rule.Key)
+			assert.Equal(t, tc.expectedReqLimit, rule.ReqLimit)
+			assert.Equal(t, tc.expectedWindowLen, rule.WindowLen)
+
+			matched, ok := limiter.MatchedPathRule(req)
+			assert.Equal(t, tc.expectedRule != "", ok)
+			assert.Equal(t, tc.expectedRule, matched.Name)
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/search", nil)
+	limiter, err = NewRequestPathLimiter(nil, nil, nil, 5, time.Minute, "host", nil, PathRules(rules), IgnorePathPrefixes([]string{"/search"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err := limiter.Rule(req)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, rule.ReqLimit)
+}
+
+func TestRequestPathLimiterPathRulesTrustedProxies(t *testing.T) {
+	limiter, err := NewRequestPathLimiter(nil, []string{"/x"}, nil, 5, time.Minute, RemoteAddrKey, nil,
+		PathRules([]PathRule{{Name: "search", Match: PathMatchPrefix, Pattern: "/search", ReqLimit: 10, WindowLen: time.Minute}}),
+		TrustedProxies([]string{"10.0.0.0/8"}),
+		ForwardedHeader("X-Forwarded-For"),
+		IPPrefixLength(24, 0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		path        string
+		expectedKey string
+	}{
+		{"/x", "request_path_limiter:203.0.113.0/24/x"},
+		{"/search", "request_path_limiter:203.0.113.0/24/search#search"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		rule, err := limiter.Rule(req)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expectedKey, rule.Key)
+	}
+}
+
+func TestRequestPathLimiterPathRulesCounter(t *testing.T) {
+	limiter, err := NewRequestPathLimiter(nil, nil, nil, 100, time.Minute, "host", func(_ *rl.Context, _ string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}, PathRules([]PathRule{
+		{Name: "export", Match: PathMatchPrefix, Pattern: "/export", ReqLimit: 1, WindowLen: time.Hour},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := rl.New(limiter)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/export", nil))
+		assert.Equal(t, want, rec.Code)
+	}
+
+}
+
+func TestPathRulesInvalid(t *testing.T) {
+	for _, rules := range [][]PathRule{
+		{{Match: PathMatchPrefix, ReqLimit: 1, WindowLen: time.Minute}},
+		{{Match: "exact", Pattern: "/a", ReqLimit: 1, WindowLen: time.Minute}},
+		{{Match: PathMatchPrefix, Pattern: "/a", ReqLimit: -1, WindowLen: time.Minute}},
+		{{Match: PathMatchPrefix, Pattern: "/a", ReqLimit: 1}},
+		{{Match: PathMatchPrefix, Pattern: "/a", ReqLimit: 1, WindowLen: time.Minute, KeyMode: RouteKeyMode(9)}},
+		{{Match: PathMatchRegexp, Pattern: "(", ReqLimit: 1, WindowLen: time.Minute}},
+		{{Match: PathMatchGlob, Pattern: "[", ReqLimit: 1, WindowLen: time.Minute}},
+		{{Match: PathMatchTemplate, Pattern: "/{id", ReqLimit: 1, WindowLen: time.Minute}},
+		{
+			{Name: "a", Match: PathMatchPrefix, Pattern: "/a", ReqLimit: 1, WindowLen: time.Minute},
+			{Name: "a", Match: PathMatchPrefix, Pattern: "/b", ReqLimit: 1, WindowLen: time.Minute},
+		},
+	} {
+		_, err := NewRequestPathLimiter(nil, nil, nil, 5, time.Minute, "host", nil, PathRules(rules))
+		assert.Error(t, err)
+	}
+}
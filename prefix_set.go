@@ -0,0 +1,88 @@
+package rlutils
+
+import (
+	"net/netip"
+	"slices"
+	"sort"
+)
+
+// prefixSet はCIDRの集合
+// プレフィックスをアドレスの範囲に変換し、重なりや隣接する範囲をまとめてから開始アドレス順に並べておく
+// 判定は二分探索で行うので、プレフィックスの数が多くてもO(log n)で済む
+type prefixSet struct {
+	ranges []addrRange
+}
+
+type addrRange struct {
+	from netip.Addr
+	to   netip.Addr
+}
+
+func newPrefixSet(prefixes []netip.Prefix) *prefixSet {
+	ranges := make([]addrRange, 0, len(prefixes))
+	for _, p := range prefixes {
+		if !p.IsValid() {
+			continue
+		}
+		p = p.Masked()
+		ranges = append(ranges, addrRange{from: p.Addr(), to: lastAddr(p)})
+	}
+	// IPv4はIPv6より前に並ぶので、アドレスファミリーをまたいでまとめられることはない
+	slices.SortFunc(ranges, func(a, b addrRange) int {
+		return a.from.Compare(b.from)
+	})
+
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if last.from.BitLen() == r.from.BitLen() && (r.from.Compare(last.to) <= 0 || last.to.Next() == r.from) {
+				if r.to.Compare(last.to) > 0 {
+					last.to = r.to
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return &prefixSet{ranges: merged}
+}
+
+func (s *prefixSet) len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.ranges)
+}
+
+func (s *prefixSet) contains(addr netip.Addr) bool {
+	if s.len() == 0 || !addr.IsValid() {
+		return false
+	}
+	addr = normalizeAddr(addr)
+	// addrより後ろから始まる最初の範囲の1つ前が、addrを含みうる唯一の範囲
+	i := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].from.Compare(addr) > 0
+	})
+	if i == 0 {
+		return false
+	}
+	r := s.ranges[i-1]
+	return r.from.BitLen() == addr.BitLen() && addr.Compare(r.to) <= 0
+}
+
+// プレフィックスに含まれる最後のアドレスを返す
+func lastAddr(p netip.Prefix) netip.Addr {
+	a := p.Addr().As16()
+	offset := 0
+	if p.Addr().Is4() {
+		offset = 12
+	}
+	for i := offset*8 + p.Bits(); i < 128; i++ {
+		a[i/8] |= 1 << (7 - i%8)
+	}
+	if p.Addr().Is4() {
+		return netip.AddrFrom4([4]byte(a[12:]))
+	}
+	return netip.AddrFrom16(a)
+}
@@ -0,0 +1,58 @@
+package rlutils
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixSet(t *testing.T) {
+	var prefixes []netip.Prefix
+	for _, p := range []string{
+		"10.0.0.0/16",
+		"10.0.128.0/17", // 10.0.0.0/16に含まれる
+		"10.1.0.0/16",   // 10.0.0.0/16に隣接する
+		"192.0.2.1/32",
+		"0.0.0.0/0",
+		"2001:db8::/32",
+		"2001:db8:1::/48",
+		"fe80::1/128",
+	} {
+		prefixes = append(prefixes, netip.MustParsePrefix(p))
+	}
+
+	tests := []struct {
+		prefixes []netip.Prefix
+		addr     string
+		want     bool
+	}{
+		{prefixes[:4], "10.0.0.0", true},
+		{prefixes[:4], "10.0.255.255", true},
+		{prefixes[:4], "10.1.255.255", true},
+		{prefixes[:4], "10.2.0.0", false},
+		{prefixes[:4], "9.255.255.255", false},
+		{prefixes[:4], "192.0.2.1", true},
+		{prefixes[:4], "192.0.2.2", false},
+		{prefixes[:4], "::ffff:10.0.0.1", true},
+		{prefixes[:4], "2001:db8::1", false},
+		{prefixes[4:], "255.255.255.255", true},
+		{prefixes[4:], "::", false},
+		{prefixes[4:], "2001:db8:ffff::1", true},
+		{prefixes[4:], "2001:db9::", false},
+		{prefixes[4:], "fe80::1", true},
+		{prefixes[4:], "fe80::1%eth0", true},
+		{prefixes[4:], "fe80::2", false},
+		{nil, "10.0.0.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			s := newPrefixSet(tt.prefixes)
+			if got := s.contains(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("contains(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+
+	if got := newPrefixSet(prefixes[:4]).len(); got != 2 {
+		t.Errorf("len() = %d, want 2", got)
+	}
+}
@@ -0,0 +1,234 @@
+package rlutils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/2manymws/rl"
+)
+
+// ReloadableLimiters は設定ファイルから作成したリミッターを再起動せずに差し替えるミドルウェア
+// 設定を読み直しても構成が変わらないリミッターは同じインスタンスを使い続けるので、カウンタが引き継がれる
+// 使わなくなったリミッターは差し替えた後にStopで止める
+type ReloadableLimiters struct {
+	path           string
+	onRequestLimit func(*rl.Context, string) http.HandlerFunc
+	setters        []Option
+	mu             sync.Mutex
+	entries        []reloadableEntry
+	loaded         os.FileInfo
+	middleware     atomic.Pointer[func(http.Handler) http.Handler]
+}
+
+type reloadableEntry struct {
+	config    LimiterConfig
+	windowLen time.Duration
+	limiter   rl.Limiter
+}
+
+// 設定ファイルからリミッターを作成する
+// setterは全てのリミッターに共通で適用される
+func NewReloadableLimiters(
+	path string,
+	onRequestLimit func(*rl.Context, string) http.HandlerFunc,
+	setter ...Option,
+) (*ReloadableLimiters, error) {
+	r := &ReloadableLimiters{
+		path:           path,
+		onRequestLimit: onRequestLimit,
+		setters:        setter,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 現在のリミッターを返す
+func (r *ReloadableLimiters) Limiters() []rl.Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	limiters := make([]rl.Limiter, 0, len(r.entries))
+	for _, e := range r.entries {
+		limiters = append(limiters, e.limiter)
+	}
+	return limiters
+}
+
+func (r *ReloadableLimiters) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		(*r.middleware.Load())(next).ServeHTTP(w, req)
+	})
+}
+
+// 設定ファイルを読み直してリミッターを差し替える
+// 設定に誤りがある場合はエラーを返し、現在のリミッターを使い続ける
+func (r *ReloadableLimiters) Reload() error {
+	// 読み込み中に書き換えられた場合に次の確認で読み直せるよう、読み込む前の状態を記録する
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	c, err := LoadConfig(r.path)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reused := make([]bool, len(r.entries))
+	entries := make([]reloadableEntry, 0, len(c.Limiters))
+	var updates []func() error
+	for i, lc := range c.Limiters {
+		settings := lc.settings()
+		structure := structuralConfig(lc)
+
+		if j := r.reusableEntry(structure, lc.WindowLen, reused); j >= 0 {
+			reused[j] = true
+			e := r.entries[j]
+			u := e.limiter.(SettingsUpdater)
+			updates = append(updates, func() error { return u.UpdateSettings(settings) })
+			entries = append(entries, e)
+			continue
+		}
+
+		l, err := lc.Build(r.onRequestLimit, r.setters...)
+		if err != nil {
+			stopNewEntries(entries, r.entries)
+			return fmt.Errorf("limiters[%d]: %w", i, err)
+		}
+		entries = append(entries, reloadableEntry{
+			config:    structure,
+			windowLen: lc.WindowLen,
+			limiter:   l,
+		})
+	}
+
+	var errs []error
+	for _, update := range updates {
+		if err := update(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		stopNewEntries(entries, r.entries)
+		return err
+	}
+
+	limiters := make([]rl.Limiter, 0, len(entries))
+	for _, e := range entries {
+		limiters = append(limiters, e.limiter)
+	}
+	middleware := New(limiters...)
+	old := r.entries
+	r.entries = entries
+	r.loaded = fi
+	r.middleware.Store(&middleware)
+
+	// 差し替えたリミッターのカウンタのgoroutineを止める
+	// 処理中のリクエストが使っていても、止めた後のカウンタは期限切れのキーを削除しなくなるだけ
+	for i, e := range old {
+		if !reused[i] {
+			stopLimiter(e.limiter)
+		}
+	}
+	return nil
+}
+
+// 読み直しに失敗した場合に、作成したリミッターを止める
+func stopNewEntries(entries, current []reloadableEntry) {
+	for _, e := range entries {
+		if !slices.ContainsFunc(current, func(c reloadableEntry) bool { return c.limiter == e.limiter }) {
+			stopLimiter(e.limiter)
+		}
+	}
+}
+
+// カウンタのgoroutineやデータベースなど、リミッターが持つ資源を解放する
+func stopLimiter(l rl.Limiter) {
+	if s, ok := l.(interface{ Stop() }); ok {
+		s.Stop()
+	}
+}
+
+// 構成が同じで、カウンタが新しいウィンドウの長さに対応できるリミッターを探す
+func (r *ReloadableLimiters) reusableEntry(structure LimiterConfig, windowLen time.Duration, reused []bool) int {
+	for i, e := range r.entries {
+		// 拒否リストとボットカタログはファイルの内容が変わっている可能性があるので、常に作り直す
+		if reused[i] || windowLen > e.windowLen || structure.Type == DenyListLimiterType || structure.BotCatalogPath != "" {
+			continue
+		}
+		if _, ok := e.limiter.(SettingsUpdater); !ok {
+			continue
+		}
+		if reflect.DeepEqual(e.config, structure) {
+			return i
+		}
+	}
+	return -1
+}
+
+// 実行中に変更できる項目を取り除いた設定を返す
+func structuralConfig(c LimiterConfig) LimiterConfig {
+	c.ReqLimit = 0
+	c.WindowLen = 0
+	c.TargetExtensions = nil
+	c.TargetMethods = nil
+	c.IgnorePathContains = nil
+	c.IgnorePathPrefixes = nil
+	c.IgnorePathSuffixes = nil
+	return c
+}
+
+// 設定ファイルの変更をinterval間隔で確認し、変更があった場合とSIGHUPを受け取った場合に読み直す
+// 読み直しに失敗した場合はonErrorにエラーを渡し、現在のリミッターを使い続ける
+// ctxがキャンセルされるまで戻らない
+func (r *ReloadableLimiters) Watch(ctx context.Context, interval time.Duration, onError func(error)) error {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+	return r.watch(ctx, interval, onError, sighup)
+}
+
+func (r *ReloadableLimiters) watch(ctx context.Context, interval time.Duration, onError func(error), sighup <-chan os.Signal) error {
+	if onError == nil {
+		onError = func(error) {}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	r.mu.Lock()
+	last := r.loaded
+	r.mu.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-sighup:
+		case <-ticker.C:
+			fi, err := os.Stat(r.path)
+			if err != nil {
+				onError(err)
+				continue
+			}
+			if last != nil && fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size() {
+				continue
+			}
+			last = fi
+		}
+		if err := r.Reload(); err != nil {
+			onError(err)
+		}
+	}
+}
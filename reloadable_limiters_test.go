@@ -0,0 +1,244 @@
+package rlutils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/2manymws/rl"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, path, config string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newReloadableLimitersHandler(t *testing.T, path string) (*ReloadableLimiters, func() int) {
+	t.Helper()
+	r, err := NewReloadableLimiters(path, func(_ *rl.Context, _ string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	return r, func() int {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+}
+
+func TestReloadableLimitersReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limiters.yml")
+	writeConfig(t, path, `
+limiters:
+  - type: ip
+    req_limit: 2
+    window_len: 1m
+  - type: host
+    req_limit: 100
+    window_len: 1m
+`)
+	r, serve := newReloadableLimitersHandler(t, path)
+	before := r.Limiters()
+
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+
+	// 制限を緩めてもカウンタは引き継がれる
+	writeConfig(t, path, `
+limiters:
+  - type: ip
+    req_limit: 3
+    window_len: 1m
+  - type: host
+    req_limit: 100
+    window_len: 1m
+`)
+	assert.NoError(t, r.Reload())
+	after := r.Limiters()
+	assert.Same(t, before[0], after[0])
+	assert.Same(t, before[1], after[1])
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+
+	// 構成が変わったリミッターは作り直される
+	writeConfig(t, path, `
+limiters:
+  - type: host
+    req_limit: 100
+    window_len: 1m
+  - type: ip
+    req_limit: 4
+    window_len: 1m
+    ipv4_prefix_len: 24
+`)
+	assert.NoError(t, r.Reload())
+	after = r.Limiters()
+	assert.Same(t, before[1], after[0])
+	assert.NotSame(t, before[0], after[1])
+	assert.Equal(t, http.StatusOK, serve())
+}
+
+func TestReloadableLimitersReloadLongerWindow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limiters.yml")
+	writeConfig(t, path, `{"limiters": [{"type": "ip", "req_limit": 2, "window_len": "1m"}]}`)
+	r, _ := newReloadableLimitersHandler(t, path)
+	before := r.Limiters()
+
+	writeConfig(t, path, `{"limiters": [{"type": "ip", "req_limit": 2, "window_len": "30s"}]}`)
+	assert.NoError(t, r.Reload())
+	assert.Same(t, before[0], r.Limiters()[0])
+
+	// カウンタが保持する期間より長いウィンドウには対応できないので作り直す
+	writeConfig(t, path, `{"limiters": [{"type": "ip", "req_limit": 2, "window_len": "1h"}]}`)
+	assert.NoError(t, r.Reload())
+	assert.NotSame(t, before[0], r.Limiters()[0])
+}
+
+func TestReloadableLimitersReloadStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limiters.yml")
+	configs := []string{
+		`{"limiters": [{"type": "ip", "req_limit": 2, "window_len": "1m", "algorithm": "gcra"}, {"type": "host", "req_limit": 2, "window_len": "1m"}]}`,
+		`{"limiters": [{"type": "ip", "req_limit": 2, "window_len": "1m", "algorithm": "token_bucket"}, {"type": "host", "req_limit": 2, "window_len": "1m", "algorithm": "token_bucket"}]}`,
+	}
+	writeConfig(t, path, configs[0])
+	r, serve := newReloadableLimitersHandler(t, path)
+	before := runtime.NumGoroutine()
+
+	// 作り直したリミッターのカウンタのgoroutineが残らない
+	for i := 1; i <= 20; i++ {
+		writeConfig(t, path, configs[i%2])
+		assert.NoError(t, r.Reload())
+		assert.Equal(t, http.StatusOK, serve())
+	}
+	// assert.Eventuallyは条件を別のgoroutineで確認するので使わない
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestReloadableLimitersReloadErrorStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limiters.yml")
+	writeConfig(t, path, `{"limiters": [{"type": "host", "req_limit": 2, "window_len": "1m"}]}`)
+	r, _ := newReloadableLimitersHandler(t, path)
+	before := runtime.NumGoroutine()
+
+	// 読み直しに失敗しても、途中まで作成したリミッターのgoroutineが残らない
+	writeConfig(t, path, `
+limiters:
+  - type: ip
+    req_limit: 2
+    window_len: 1m
+    algorithm: gcra
+  - type: country
+    req_limit: 2
+    window_len: 1m
+    db_path: testdata/not_found.mmdb
+    countries: [US]
+`)
+	for i := 0; i < 10; i++ {
+		assert.Error(t, r.Reload())
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestReloadableLimitersReloadCountry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limiters.yml")
+	config := `{"limiters": [{"type": "country", "req_limit": 2, "window_len": "%s", "db_path": "testdata/GeoIP2-Country-Test.mmdb", "countries": ["*"]}]}`
+	writeConfig(t, path, fmt.Sprintf(config, "1m"))
+	r, _ := newReloadableLimitersHandler(t, path)
+	before := r.Limiters()[0].(*CountryLimiter)
+
+	// 作り直したリミッターのデータベースは閉じる
+	writeConfig(t, path, fmt.Sprintf(config, "1h"))
+	assert.NoError(t, r.Reload())
+	assert.NotSame(t, before, r.Limiters()[0])
+	assert.True(t, before.closed)
+	rule, err := before.Rule(httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, -1, rule.ReqLimit)
+}
+
+func TestReloadableLimitersReloadError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limiters.yml")
+	writeConfig(t, path, `{"limiters": [{"type": "ip", "req_limit": 1, "window_len": "1m"}]}`)
+	r, serve := newReloadableLimitersHandler(t, path)
+	before := r.Limiters()
+
+	for _, config := range []string{
+		`{"limiters": [{"type": "ip", "req_limit": 10}]}`,
+		`{"limiters": [{"type": "country", "req_limit": 10, "window_len": "1m", "db_path": "testdata/not_found.mmdb", "countries": ["US"]}]}`,
+		`limiters: [`,
+	} {
+		writeConfig(t, path, config)
+		assert.Error(t, r.Reload())
+		assert.Equal(t, before, r.Limiters())
+	}
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+
+	_, err := NewReloadableLimiters(filepath.Join(t.TempDir(), "not_found.yml"), nil)
+	assert.Error(t, err)
+}
+
+func TestReloadableLimitersWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limiters.yml")
+	writeConfig(t, path, `{"limiters": [{"type": "ip", "req_limit": 1, "window_len": "1m"}]}`)
+	r, _ := newReloadableLimitersHandler(t, path)
+	reqLimit := func() int {
+		return r.Limiters()[0].(SettingsUpdater).Settings().ReqLimit
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	sighup := make(chan os.Signal, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- r.watch(ctx, time.Millisecond, func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		}, sighup)
+	}()
+
+	writeConfig(t, path, `{"limiters": [{"type": "ip", "req_limit": 10, "window_len": "1m"}]}`)
+	assert.Eventually(t, func() bool { return reqLimit() == 10 }, time.Second, time.Millisecond)
+
+	writeConfig(t, path, `{"limiters": [{"type": "ip", "req_limit": -10, "window_len": "1m"}]}`)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(errs) > 0
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 10, reqLimit())
+
+	writeConfig(t, path, `{"limiters": [{"type": "ip", "req_limit": 20, "window_len": "1m"}]}`)
+	sighup <- syscall.SIGHUP
+	assert.Eventually(t, func() bool { return reqLimit() == 20 }, time.Second, time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
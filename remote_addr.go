@@ -0,0 +1,137 @@
+package rlutils
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// アドレス文字列をnetip.Addrに変換する
+// ポート、IPv6の角括弧、ゾーンIDを取り除き、IPv4射影IPv6アドレスはIPv4に正規化する
+func parseAddr(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return normalizeAddr(ap.Addr()), nil
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return normalizeAddr(addr), nil
+}
+
+func normalizeAddr(addr netip.Addr) netip.Addr {
+	return addr.Unmap().WithZone("")
+}
+
+// リクエスト元のアドレスを正規化した文字列で返す
+// 解釈できないアドレスの場合はポートを取り除いた値をそのまま返す
+func remoteAddr(r *http.Request) string {
+	addr, err := parseAddr(r.RemoteAddr)
+	if err == nil {
+		return addr.String()
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+const (
+	forwardedHeader     = "Forwarded"
+	xForwardedForHeader = "X-Forwarded-For"
+	xRealIPHeader       = "X-Real-Ip"
+)
+
+// 信頼するプロキシ経由のリクエストであれば、転送ヘッダーを右から辿って最初の信頼できないホップを返す
+// 信頼できないピアから届いた転送ヘッダーは無視する
+// headerに指定したヘッダーだけを使い、プロキシが書き込まない他のヘッダーに切り替えることはない
+func clientAddr(r *http.Request, trustedProxies []netip.Prefix, header string) string {
+	if len(trustedProxies) == 0 || header == "" {
+		return remoteAddr(r)
+	}
+	peer, err := parseAddr(r.RemoteAddr)
+	if err != nil || !containsAddr(trustedProxies, peer) {
+		return remoteAddr(r)
+	}
+
+	client := peer
+	hops := headerHops(r.Header, header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := parseAddr(hops[i])
+		if err != nil {
+			// unknownや難読化された識別子より先は辿れないので、直近の既知のホップを使う
+			break
+		}
+		client = addr
+		if !containsAddr(trustedProxies, addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// ヘッダーに書かれた転送経路を左から順に返す
+func headerHops(h http.Header, name string) []string {
+	var hops []string
+	switch name {
+	case forwardedHeader:
+		for _, v := range h.Values(forwardedHeader) {
+			for _, element := range strings.Split(v, ",") {
+				hops = append(hops, forwardedFor(element))
+			}
+		}
+	case xForwardedForHeader:
+		for _, v := range h.Values(xForwardedForHeader) {
+			for _, hop := range strings.Split(v, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	case xRealIPHeader:
+		if v := h.Get(xRealIPHeader); v != "" {
+			hops = append(hops, strings.TrimSpace(v))
+		}
+	}
+	return hops
+}
+
+// RFC 7239のforwarded-elementからfor=の値を取り出す
+func forwardedFor(element string) string {
+	for _, pair := range strings.Split(element, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(k, "for") {
+			return strings.Trim(v, `"`)
+		}
+	}
+	return ""
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// CIDR表記またはアドレス単体の文字列をnetip.Prefixに変換する
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := parseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,279 @@
+package rlutils
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRemoteAddr(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       string
+	}{
+		{
+			name:       "IPv4 without port",
+			remoteAddr: "10.0.0.1",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "IPv4 with port",
+			remoteAddr: "10.0.0.1:12345",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "IPv6 with port",
+			remoteAddr: "[2001:db8::1]:443",
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "IPv6 without port",
+			remoteAddr: "2001:db8::1",
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "IPv6 with brackets and without port",
+			remoteAddr: "[2001:db8::1]",
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "IPv6 is canonicalized",
+			remoteAddr: "[2001:0db8:0000::0001]:443",
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "IPv6 with zone",
+			remoteAddr: "[fe80::1%eth0]:80",
+			want:       "fe80::1",
+		},
+		{
+			name:       "IPv4-mapped IPv6",
+			remoteAddr: "[::ffff:10.0.0.1]:80",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "Invalid address with port",
+			remoteAddr: "invalid-ip:80",
+			want:       "invalid-ip",
+		},
+		{
+			name:       "Invalid address",
+			remoteAddr: "invalid-ip",
+			want:       "invalid-ip",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if got := remoteAddr(req); got != tt.want {
+				t.Errorf("remoteAddr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "2001:db8:ffff::/48"}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string // 指定しない場合はX-Forwarded-For
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "Direct request",
+			remoteAddr: "192.0.2.1:1234",
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "Spoofed header from untrusted peer is ignored",
+			remoteAddr: "192.0.2.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "X-Forwarded-For from trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "X-Forwarded-For picks first untrusted hop from the right",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.1, 10.0.0.2"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "X-Forwarded-For with only trusted hops picks leftmost",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "X-Forwarded-For with invalid hop uses nearest known hop",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1, garbage, 10.0.0.2"},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "X-Real-IP from trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			header:     "X-Real-IP",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			// X-Real-IPだけを書き込むプロキシは、クライアントが送ったX-Forwarded-Forをそのまま転送する
+			name:       "Client-sent X-Forwarded-For does not override X-Real-IP",
+			remoteAddr: "10.0.0.1:1234",
+			header:     "X-Real-IP",
+			headers: map[string]string{
+				"X-Real-IP":       "203.0.113.9",
+				"X-Forwarded-For": "1.2.3.4",
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:       "Unconfigured header from trusted proxy is ignored",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.1"},
+			want:       "10.0.0.1",
+		},
+		{
+			// XFFを追記するだけのロードバランサーは、クライアントが送ったForwardedをそのまま転送する
+			name:       "Client-sent Forwarded does not override X-Forwarded-For",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string]string{
+				"Forwarded":       "for=192.0.2.10",
+				"X-Forwarded-For": "203.0.113.9",
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:       "Forwarded from trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			header:     "Forwarded",
+			headers:    map[string]string{"Forwarded": `for=198.51.100.1;proto=https, for="[2001:db8:ffff::1]:4711"`},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "Forwarded with IPv6 client",
+			remoteAddr: "[2001:db8:ffff::2]:443",
+			header:     "Forwarded",
+			headers:    map[string]string{"Forwarded": `For="[2001:db8:cafe::17]:4711"`},
+			want:       "2001:db8:cafe::17",
+		},
+		{
+			name:       "Forwarded with obfuscated identifier uses nearest known hop",
+			remoteAddr: "10.0.0.1:1234",
+			header:     "Forwarded",
+			headers:    map[string]string{"Forwarded": "for=_hidden, for=10.0.0.2"},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "No forwarding headers from trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == "" {
+				header = "X-Forwarded-For"
+			}
+			bl := NewBaseLimiter(0, 0, nil, TrustedProxies(trusted), ForwardedHeader(header))
+			if err := bl.Err(); err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := bl.remoteAddr(req); got != tt.want {
+				t.Errorf("remoteAddr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientAddr_ForwardedHeader(t *testing.T) {
+	headers := map[string]string{
+		"Forwarded":       "for=192.0.2.10",
+		"X-Forwarded-For": "203.0.113.9",
+		"X-Real-IP":       "198.51.100.1",
+	}
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"Forwarded", "192.0.2.10"},
+		{"x-forwarded-for", "203.0.113.9"},
+		{"X-Real-IP", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			bl := NewBaseLimiter(0, 0, nil, TrustedProxies([]string{"10.0.0.0/8"}), ForwardedHeader(tt.header))
+			if err := bl.Err(); err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			for k, v := range headers {
+				req.Header.Set(k, v)
+			}
+			if got := bl.remoteAddr(req); got != tt.want {
+				t.Errorf("remoteAddr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 指定したヘッダーがない場合は他のヘッダーを使わない
+	bl := NewBaseLimiter(0, 0, nil, TrustedProxies([]string{"10.0.0.0/8"}), ForwardedHeader("X-Forwarded-For"))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("Forwarded", "for=192.0.2.10")
+	if got := bl.remoteAddr(req); got != "10.0.0.1" {
+		t.Errorf("remoteAddr() = %v, want %v", got, "10.0.0.1")
+	}
+
+	bl = NewBaseLimiter(0, 0, nil, ForwardedHeader("X-Client-IP"))
+	if bl.Err() == nil {
+		t.Error("expected error for invalid forwarded header")
+	}
+}
+
+func TestTrustedProxiesInvalidCIDR(t *testing.T) {
+	if err := NewIPLimiter(1, 0, nil, TrustedProxies([]string{"10.0.0.0/33"}), ForwardedHeader("X-Forwarded-For")).Err(); err == nil {
+		t.Errorf("Expected an error but did not get one")
+	}
+}
+
+func TestTrustedProxiesRequireForwardedHeader(t *testing.T) {
+	// 読むヘッダーを推測すると、プロキシが書き込まないヘッダーでクライアントがアドレスを偽れる
+	if err := NewIPLimiter(1, time.Minute, nil, TrustedProxies([]string{"10.0.0.0/8"})).Err(); err == nil {
+		t.Fatal("Expected an error but did not get one")
+	}
+
+	limiter := NewIPLimiter(1, time.Minute, nil, TrustedProxies([]string{"10.0.0.0/8"}), ForwardedHeader("X-Real-IP"))
+	if err := limiter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Real-IP", "203.0.113.9")
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	rule, err := limiter.Rule(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Key != "ip_limiter:203.0.113.9" {
+		t.Errorf("Key = %v, want %v", rule.Key, "ip_limiter:203.0.113.9")
+	}
+}
@@ -15,12 +15,16 @@ type RequestPathLimiter struct {
 	ignorePathContains  []string
 	ignorePathPrefixes  []string
 	ignorePathSuffixes  []string
-	key                 string
-	BaseLimiter
+	subjectKey          KeyFunc
+	Limiter
 }
 
 // リクエストパスごとにリクエスト数を制限する
 // 制限単位はホスト名 + リクエストパス
+// RequestPathRegexps, RequestPathGlobsでパターンを指定した場合は、パターンごとに数える
+// RequestPathTemplatesでルートテンプレートを指定した場合は、テンプレートまたはプレースホルダの値ごとに数える
+// 文字列、パターン、テンプレートの順に判定し、最初にマッチしたものを使う
+// PathRulesでルールを指定した場合は、それらより先にルールを判定する
 func NewRequestPathLimiter(
 	requestPathContains []string,
 	requestPathPrefixes []string,
@@ -32,33 +36,58 @@ func NewRequestPathLimiter(
 	setter ...Option,
 
 ) (*RequestPathLimiter, error) {
-	err := validateKey(key)
-	if err != nil {
-		return nil, err
-	}
-
-	return &RequestPathLimiter{
+	l := &RequestPathLimiter{
 		requestPathContains: requestPathContains,
 		requestPathPrefixes: requestPathPrefixes,
 		requestPathSuffixes: requestPathSuffixes,
-		key:                 key,
-		BaseLimiter: NewBaseLimiter(
-			reqLimit,
-			windowLen,
-			onRequestLimit,
-			setter...,
-		),
-	}, nil
-}
-
-func (l *RequestPathLimiter) Name() string {
-	return "request_path_limiter"
+	}
+	base, err := NewLimiter(
+		"request_path_limiter",
+		nil,
+		l.requestPathKey,
+		reqLimit,
+		windowLen,
+		onRequestLimit,
+		append([]Option{acceptOptions("RequestPathRegexps", "RequestPathGlobs", "RequestPathTemplates", "PathRules")}, setter...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	l.Limiter = *base
+	l.subjectKey = l.keyBy
+	if l.subjectKey == nil {
+		f, err := ParseKey(key)
+		if err != nil {
+			l.Stop()
+			return nil, err
+		}
+		l.subjectKey = f
+	}
+	return l, nil
 }
 
+// PathRulesにマッチした場合はルールの上限とウィンドウを使い、キーにルールの名前を含める
 func (l *RequestPathLimiter) Rule(r *http.Request) (*rl.Rule, error) {
-	if !l.IsTargetRequest(r) {
+	if len(l.pathRules) == 0 {
+		return l.Limiter.Rule(r)
+	}
+	s := l.settings.Load()
+	if !l.isTargetRequest(s, r) {
 		return &rl.Rule{ReqLimit: -1}, nil
 	}
+	rule, path, ok := l.matchPathRule(r)
+	if !ok {
+		return l.Limiter.Rule(r)
+	}
+	// RemoteAddrKeyFuncがTrustedProxiesやIPPrefixLengthに従うように、リミッターを通して呼び出す
+	key, ok := l.key(r, l.subjectKey)
+	if !ok {
+		return &rl.Rule{ReqLimit: -1}, nil
+	}
+	return l.newRule(l.namespacedKey(key+path+"#"+rule.Name), rule.ReqLimit, rule.WindowLen), nil
+}
+
+func (l *RequestPathLimiter) requestPathKey(r *http.Request) (string, bool) {
 	for _, st := range []struct {
 		path []string
 		f    func(string, string) bool
@@ -70,18 +99,32 @@ func (l *RequestPathLimiter) Rule(r *http.Request) (*rl.Rule, error) {
 		if len(st.path) > 0 {
 			for _, path := range st.path {
 				if st.f(r.URL.Path, path) {
-					return &rl.Rule{
-						Key:       fillKey(r, l.key) + path,
-						ReqLimit:  l.reqLimit,
-						WindowLen: l.windowLen,
-					}, nil
+					key, ok := l.key(r, l.subjectKey)
+					if !ok {
+						return "", false
+					}
+					return key + path, true
 				}
 			}
 		}
 	}
-	return &rl.Rule{ReqLimit: -1}, nil
-}
-
-func (l *RequestPathLimiter) OnRequestLimit(r *rl.Context) http.HandlerFunc {
-	return l.onRequestLimit(r, l.Name())
+	for _, p := range l.requestPathPatterns {
+		if p.MatchString(r.URL.Path) {
+			key, ok := l.key(r, l.subjectKey)
+			if !ok {
+				return "", false
+			}
+			return key + p.String(), true
+		}
+	}
+	for _, t := range l.requestPathTemplates {
+		if values, ok := t.Match(r.URL.Path); ok {
+			key, ok := l.key(r, l.subjectKey)
+			if !ok {
+				return "", false
+			}
+			return key + t.key(values), true
+		}
+	}
+	return "", false
 }
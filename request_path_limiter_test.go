@@ -19,6 +19,7 @@ func TestRequestPathLimiter(t *testing.T) {
 		ignoreContains      []string
 		ignorePrefixes      []string
 		ignoreSuffixes      []string
+		options             []Option
 		path                string
 		key                 string
 		expectedToBeLimited bool
@@ -32,7 +33,7 @@ func TestRequestPathLimiter(t *testing.T) {
 			path:                "/accounts/user/profile",
 			key:                 "host",
 			expectedToBeLimited: true,
-			expectedKey:         "example.com/user",
+			expectedKey:         "request_path_limiter:example.com/user",
 		},
 		{
 			name:                "Path starts with limited prefix",
@@ -42,7 +43,7 @@ func TestRequestPathLimiter(t *testing.T) {
 			path:                "/api/users/1",
 			key:                 "host",
 			expectedToBeLimited: true,
-			expectedKey:         "example.com/api/",
+			expectedKey:         "request_path_limiter:example.com/api/",
 		},
 		{
 			name:                "Path ends with limited suffix",
@@ -52,7 +53,7 @@ func TestRequestPathLimiter(t *testing.T) {
 			path:                "/users/1/details",
 			key:                 "host",
 			expectedToBeLimited: true,
-			expectedKey:         "example.com/details",
+			expectedKey:         "request_path_limiter:example.com/details",
 		},
 		{
 			name:                "Path does not match any criteria",
@@ -104,7 +105,77 @@ func TestRequestPathLimiter(t *testing.T) {
 			path:                "/accounts/user/profile",
 			key:                 "remote_addr",
 			expectedToBeLimited: true,
-			expectedKey:         "127.0.0.1/user",
+			expectedKey:         "request_path_limiter:127.0.0.1/user",
+		},
+		{
+			name:                "Path matches regexp",
+			options:             []Option{RequestPathRegexps([]string{`^/api/v[0-9]+/search$`})},
+			path:                "/api/v2/search",
+			key:                 "host",
+			expectedToBeLimited: true,
+			expectedKey:         "request_path_limiter:example.com^/api/v[0-9]+/search$",
+		},
+		{
+			name:                "Path does not match regexp",
+			options:             []Option{RequestPathRegexps([]string{`^/api/v[0-9]+/search$`})},
+			path:                "/api/v2/search/more",
+			key:                 "host",
+			expectedToBeLimited: false,
+		},
+		{
+			name:                "Path matches glob",
+			options:             []Option{RequestPathGlobs([]string{"/assets/**/*.map"})},
+			path:                "/assets/js/vendor/app.js.map",
+			key:                 "host",
+			expectedToBeLimited: true,
+			expectedKey:         `request_path_limiter:example.com^/assets/(?:.*/)?[^/]*\.map$`,
+		},
+		{
+			name:                "Literal match takes precedence over patterns",
+			prefixes:            []string{"/api/"},
+			options:             []Option{RequestPathRegexps([]string{`^/api/v[0-9]+/search$`})},
+			path:                "/api/v2/search",
+			key:                 "host",
+			expectedToBeLimited: true,
+			expectedKey:         "request_path_limiter:example.com/api/",
+		},
+		{
+			name:                "Path matches route template",
+			options:             []Option{RequestPathTemplates([]string{"/users/{id}/export"}, RouteKeyTemplate)},
+			path:                "/users/42/export",
+			key:                 "remote_addr",
+			expectedToBeLimited: true,
+			expectedKey:         "request_path_limiter:127.0.0.1/users/{id}/export",
+		},
+		{
+			name:                "Path matches route template with values",
+			options:             []Option{RequestPathTemplates([]string{"/users/{id}/export"}, RouteKeyValues)},
+			path:                "/users/42/export",
+			key:                 "remote_addr",
+			expectedToBeLimited: true,
+			expectedKey:         "request_path_limiter:127.0.0.1/users/{id}/export:id=42",
+		},
+		{
+			name:                "Path does not match route template",
+			options:             []Option{RequestPathTemplates([]string{"/users/{id}/export"}, RouteKeyValues)},
+			path:                "/users/42/import",
+			key:                 "remote_addr",
+			expectedToBeLimited: false,
+		},
+		{
+			name:                "Path is ignored by glob",
+			options:             []Option{RequestPathGlobs([]string{"/api/**"}), IgnorePathGlobs([]string{"/api/internal/*"})},
+			path:                "/api/internal/status",
+			key:                 "host",
+			expectedToBeLimited: false,
+		},
+		{
+			name:                "Path is ignored by regexp",
+			prefixes:            []string{"/api/"},
+			options:             []Option{IgnorePathRegexps([]string{`/health(z)?$`})},
+			path:                "/api/healthz",
+			key:                 "host",
+			expectedToBeLimited: false,
 		},
 	}
 
@@ -125,9 +196,11 @@ func TestRequestPathLimiter(t *testing.T) {
 				windowLen,
 				tc.key,
 				nil,
-				IgnorePathContains(tc.ignoreContains),
-				IgnorePathPrefixes(tc.ignorePrefixes),
-				IgnorePathSuffixes(tc.ignoreSuffixes),
+				append([]Option{
+					IgnorePathContains(tc.ignoreContains),
+					IgnorePathPrefixes(tc.ignorePrefixes),
+					IgnorePathSuffixes(tc.ignoreSuffixes),
+				}, tc.options...)...,
 			)
 
 			// Using the mock counter instead of the real one.
@@ -149,3 +222,16 @@ func TestRequestPathLimiter(t *testing.T) {
 		})
 	}
 }
+
+func TestRequestPathLimiterInvalidPattern(t *testing.T) {
+	for _, option := range []Option{
+		RequestPathRegexps([]string{"^/api/(v1"}),
+		RequestPathGlobs([]string{"/api/[a-"}),
+		IgnorePathRegexps([]string{"*"}),
+		IgnorePathGlobs([]string{`/api\`}),
+		RequestPathTemplates([]string{"/users/{id"}, RouteKeyTemplate),
+	} {
+		_, err := NewRequestPathLimiter(nil, []string{"/api/"}, nil, 5, time.Minute, "host", nil, option)
+		assert.Error(t, err)
+	}
+}
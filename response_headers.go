@@ -0,0 +1,188 @@
+package rlutils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/2manymws/rl"
+)
+
+// HeaderMode はリミッターがレスポンスに付けるヘッダーの種類
+type HeaderMode string
+
+const (
+	// ヘッダーを付けない
+	HeaderModeNone HeaderMode = "none"
+	// X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Resetを付ける
+	HeaderModeXRateLimit HeaderMode = "x_ratelimit"
+	// IETFのドラフトのRateLimitとRateLimit-Policyを付ける
+	HeaderModeIETF HeaderMode = "ietf"
+)
+
+const (
+	xRateLimitLimitHeader     = "X-RateLimit-Limit"
+	xRateLimitRemainingHeader = "X-RateLimit-Remaining"
+	xRateLimitResetHeader     = "X-RateLimit-Reset"
+	rateLimitHeader           = "RateLimit"
+	rateLimitPolicyHeader     = "RateLimit-Policy"
+	retryAfterHeader          = "Retry-After"
+)
+
+// リミッターがレスポンスに付けるヘッダーを指定する
+// 指定しない場合はヘッダーを付けない
+// HeaderModeNone以外を指定すると、制限したレスポンスにはリセットまでの秒数をRetry-Afterに付ける
+// HeaderModeIETFの場合は、rl.Newの代わりにNewでミドルウェアを作成する
+func ResponseHeaders(mode HeaderMode) Option {
+	return func(args *Options) {
+		switch mode {
+		case HeaderModeNone, HeaderModeXRateLimit, HeaderModeIETF:
+			args.HeaderMode = mode
+		default:
+			args.errs = append(args.errs, fmt.Errorf("invalid header mode: %q", mode))
+		}
+	}
+}
+
+// rl.Newと同じミドルウェアを作成する
+// 制限しなかったリクエストのレスポンスに、最後に判定したリミッターのHeaderModeIETFのヘッダーを付ける
+func New(limiters ...rl.Limiter) func(next http.Handler) http.Handler {
+	var ietf bool
+	for _, l := range limiters {
+		if responseHeaderMode(l) == HeaderModeIETF {
+			ietf = true
+		}
+	}
+	if !ietf {
+		return rl.New(limiters...)
+	}
+	recording := make([]rl.Limiter, 0, len(limiters))
+	for _, l := range limiters {
+		recording = append(recording, recordRule(l))
+	}
+	middleware := rl.New(recording...)
+	return func(next http.Handler) http.Handler {
+		h := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if w.Header().Get(xRateLimitLimitHeader) != "" {
+				setIETFHeaders(w, r)
+			}
+			next.ServeHTTP(w, r)
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ruleRecordKey{}, &ruleRecord{})))
+		})
+	}
+}
+
+type ruleRecordKey struct{}
+
+// rlが判定したルールのうち、最後に有効だったリミッターとルールをリクエストごとに記録する
+// rlはルールが有効な最後のリミッターの値でX-RateLimit-*を付ける
+type ruleRecord struct {
+	limiter rl.Limiter
+	rule    *rl.Rule
+}
+
+// Ruleの結果を記録するリミッター
+// ルールの判定にはボディの読み込みや国の検索などが伴うため、ヘッダーを付けるために判定し直さない
+type recordingLimiter struct {
+	rl.Limiter
+}
+
+// rlはCounterを実装したリミッターのGetとIncrementを使うので、包んでも引き継ぐ
+type recordingCounterLimiter struct {
+	recordingLimiter
+	rl.Counter
+}
+
+func recordRule(l rl.Limiter) rl.Limiter {
+	if c, ok := l.(rl.Counter); ok {
+		return recordingCounterLimiter{recordingLimiter{l}, c}
+	}
+	return recordingLimiter{l}
+}
+
+func (l recordingLimiter) Rule(r *http.Request) (*rl.Rule, error) {
+	rule, err := l.Limiter.Rule(r)
+	if err != nil || rule.ReqLimit < 0 {
+		return rule, err
+	}
+	if rec, ok := r.Context().Value(ruleRecordKey{}).(*ruleRecord); ok {
+		rec.limiter, rec.rule = l.Limiter, rule
+	}
+	return rule, nil
+}
+
+func responseHeaderMode(l rl.Limiter) HeaderMode {
+	if m, ok := l.(interface{ responseHeaderMode() HeaderMode }); ok {
+		return m.responseHeaderMode()
+	}
+	return HeaderModeNone
+}
+
+func (l *BaseLimiter) responseHeaderMode() HeaderMode {
+	return l.headerMode
+}
+
+// rlがX-RateLimit-*を付けたリミッターがHeaderModeIETFであれば、IETFのヘッダーに置き換える
+func setIETFHeaders(w http.ResponseWriter, r *http.Request) {
+	rec, ok := r.Context().Value(ruleRecordKey{}).(*ruleRecord)
+	if !ok || rec.limiter == nil || responseHeaderMode(rec.limiter) != HeaderModeIETF {
+		return
+	}
+	h := w.Header()
+	remaining, err := strconv.Atoi(h.Get(xRateLimitRemainingHeader))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(h.Get(xRateLimitResetHeader), 10, 64)
+	if err != nil {
+		return
+	}
+	deleteXRateLimitHeaders(h)
+	setRateLimitHeaders(h, rec.limiter.Name(), rec.rule.ReqLimit, rec.rule.WindowLen, remaining, secondsUntil(reset))
+}
+
+// 制限した時のヘッダーを付けてからonRequestLimitを呼び出す
+func (l *Limiter) withResponseHeaders(c *rl.Context, h http.HandlerFunc) http.HandlerFunc {
+	if l.headerMode == "" || l.headerMode == HeaderModeNone {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		retryAfter := secondsUntil(int64(c.RateLimitReset))
+		if rc, ok := l.Counter.(ruleCounter); ok {
+			// トークンバケットなどの場合は、次のリクエストを受け付けられるまでの秒数
+			if s, ok := rc.retryAfter(c.Key); ok {
+				retryAfter = s
+			}
+		}
+		header.Set(retryAfterHeader, strconv.Itoa(retryAfter))
+		if l.headerMode == HeaderModeIETF {
+			deleteXRateLimitHeaders(header)
+			setRateLimitHeaders(header, l.Name(), c.RequestLimit, c.WindowLen, c.RateLimitRemaining, retryAfter)
+		}
+		h(w, r)
+	}
+}
+
+func deleteXRateLimitHeaders(h http.Header) {
+	h.Del(xRateLimitLimitHeader)
+	h.Del(xRateLimitRemainingHeader)
+	h.Del(xRateLimitResetHeader)
+}
+
+func setRateLimitHeaders(h http.Header, name string, limit int, windowLen time.Duration, remaining, reset int) {
+	policy := strconv.Quote(name)
+	h.Set(rateLimitPolicyHeader, fmt.Sprintf("%s;q=%d;w=%d", policy, limit, int(windowLen.Seconds())))
+	h.Set(rateLimitHeader, fmt.Sprintf("%s;r=%d;t=%d", policy, remaining, reset))
+}
+
+// Unix時刻までの秒数を切り上げて返す
+// 過ぎている場合でも、すぐに再送されないように1秒を返す
+func secondsUntil(unix int64) int {
+	d := time.Until(time.Unix(unix, 0))
+	return max(int((d+time.Second-1)/time.Second), 1)
+}
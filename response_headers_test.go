@@ -0,0 +1,158 @@
+package rlutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/2manymws/rl"
+	"github.com/stretchr/testify/assert"
+)
+
+func newHeaderTestLimiter(t *testing.T, name string, matcher func(*http.Request) bool, mode HeaderMode) *Limiter {
+	t.Helper()
+	limiter, err := NewLimiter(
+		name,
+		matcher,
+		HostKeyFunc(),
+		2,
+		time.Hour,
+		func(_ *rl.Context, _ string) http.HandlerFunc {
+			return func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		},
+		ResponseHeaders(mode),
+	)
+	assert.NoError(t, err)
+	return limiter
+}
+
+func serveHeaderTest(h http.Handler) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	return rec
+}
+
+func assertRetryAfter(t *testing.T, h http.Header) {
+	t.Helper()
+	retryAfter, err := strconv.Atoi(h.Get("Retry-After"))
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, retryAfter, 1)
+	assert.LessOrEqual(t, retryAfter, 3600)
+}
+
+func TestResponseHeaders_None(t *testing.T) {
+	h := New(newHeaderTestLimiter(t, "test_limiter", nil, HeaderModeNone))(okHandler())
+	for _, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rec := serveHeaderTest(h)
+		assert.Equal(t, want, rec.Code)
+		assert.Empty(t, rec.Header())
+	}
+}
+
+func TestResponseHeaders_XRateLimit(t *testing.T) {
+	h := New(newHeaderTestLimiter(t, "test_limiter", nil, HeaderModeXRateLimit))(okHandler())
+	for _, want := range []string{"2", "1"} {
+		rec := serveHeaderTest(h)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, want, rec.Header().Get("X-RateLimit-Remaining"))
+		assert.NotEmpty(t, rec.Header().Get("X-RateLimit-Reset"))
+		assert.Empty(t, rec.Header().Get("Retry-After"))
+	}
+	rec := serveHeaderTest(h)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+	assertRetryAfter(t, rec.Header())
+}
+
+func TestResponseHeaders_IETF(t *testing.T) {
+	rateLimit := regexp.MustCompile(`^"test_limiter";r=(\d+);t=(\d+)$`)
+	h := New(newHeaderTestLimiter(t, "test_limiter", nil, HeaderModeIETF))(okHandler())
+	for _, want := range []string{"2", "1"} {
+		rec := serveHeaderTest(h)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `"test_limiter";q=2;w=3600`, rec.Header().Get("RateLimit-Policy"))
+		m := rateLimit.FindStringSubmatch(rec.Header().Get("RateLimit"))
+		if assert.NotNil(t, m) {
+			assert.Equal(t, want, m[1])
+		}
+		assert.Empty(t, rec.Header().Get("X-RateLimit-Limit"))
+		assert.Empty(t, rec.Header().Get("X-RateLimit-Remaining"))
+		assert.Empty(t, rec.Header().Get("X-RateLimit-Reset"))
+	}
+	rec := serveHeaderTest(h)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, `"test_limiter";q=2;w=3600`, rec.Header().Get("RateLimit-Policy"))
+	m := rateLimit.FindStringSubmatch(rec.Header().Get("RateLimit"))
+	if assert.NotNil(t, m) {
+		assert.Equal(t, "0", m[1])
+		assert.Equal(t, rec.Header().Get("Retry-After"), m[2])
+	}
+	assert.Empty(t, rec.Header().Get("X-RateLimit-Limit"))
+	assertRetryAfter(t, rec.Header())
+}
+
+func TestResponseHeaders_LastLimiter(t *testing.T) {
+	// ルールが有効な最後のリミッターのヘッダーの種類に従う
+	skip := func(*http.Request) bool { return false }
+	h := New(
+		newHeaderTestLimiter(t, "x_limiter", nil, HeaderModeXRateLimit),
+		newHeaderTestLimiter(t, "ietf_limiter", skip, HeaderModeIETF),
+	)(okHandler())
+	rec := serveHeaderTest(h)
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+	assert.Empty(t, rec.Header().Get("RateLimit"))
+
+	h = New(
+		newHeaderTestLimiter(t, "x_limiter", nil, HeaderModeXRateLimit),
+		newHeaderTestLimiter(t, "ietf_limiter", nil, HeaderModeIETF),
+	)(okHandler())
+	rec = serveHeaderTest(h)
+	assert.Empty(t, rec.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, `"ietf_limiter";q=2;w=3600`, rec.Header().Get("RateLimit-Policy"))
+}
+
+func TestResponseHeaders_RuleOnce(t *testing.T) {
+	// ヘッダーを付けるためにルールを判定し直さない
+	var matched, keyed int
+	counting, err := NewLimiter(
+		"ietf_limiter",
+		func(*http.Request) bool {
+			matched++
+			return true
+		},
+		func(r *http.Request) (string, bool) {
+			keyed++
+			return r.Host, true
+		},
+		2,
+		time.Hour,
+		nil,
+		ResponseHeaders(HeaderModeIETF),
+	)
+	assert.NoError(t, err)
+	h := New(newHeaderTestLimiter(t, "x_limiter", nil, HeaderModeXRateLimit), counting)(okHandler())
+	for i := 1; i <= 2; i++ {
+		rec := serveHeaderTest(h)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `"ietf_limiter";q=2;w=3600`, rec.Header().Get("RateLimit-Policy"))
+		assert.Equal(t, i, matched)
+		assert.Equal(t, i, keyed)
+	}
+}
+
+func TestResponseHeaders_Invalid(t *testing.T) {
+	_, err := NewLimiter("test_limiter", nil, HostKeyFunc(), 2, time.Hour, nil, ResponseHeaders("draft"))
+	assert.Error(t, err)
+}
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,116 @@
+package rlutils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RouteKeyMode はルートテンプレートにマッチしたリクエストのキーの作り方
+type RouteKeyMode int
+
+const (
+	// テンプレートだけをキーにし、プレースホルダの値に関わらずまとめて数える
+	RouteKeyTemplate RouteKeyMode = iota
+	// テンプレートとプレースホルダの値をキーにし、値ごとに数える
+	RouteKeyValues
+)
+
+var placeholderNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// RouteTemplate は/users/{id}/exportのような名前付きプレースホルダを持つルート
+// {name}は/以外の1文字以上に、{name:pattern}は正規表現patternにマッチする
+type RouteTemplate struct {
+	Template string
+	KeyMode  RouteKeyMode
+	names    []string
+	re       *regexp.Regexp
+}
+
+// ルートテンプレートを解釈する
+func ParseRouteTemplate(template string, mode RouteKeyMode) (*RouteTemplate, error) {
+	if mode != RouteKeyTemplate && mode != RouteKeyValues {
+		return nil, fmt.Errorf("invalid route key mode: %d", mode)
+	}
+	var (
+		b     strings.Builder
+		names []string
+		seen  = map[string]struct{}{}
+	)
+	b.WriteString("^")
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c == '}' {
+			return nil, fmt.Errorf("invalid route template: %s: unexpected }", template)
+		}
+		if c != '{' {
+			b.WriteString(regexp.QuoteMeta(template[i : i+1]))
+			continue
+		}
+		// 正規表現の{n,m}を含められるよう、対応する}まで読む
+		depth := 1
+		end := i + 1
+		for ; end < len(template) && depth > 0; end++ {
+			switch template[end] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+			}
+		}
+		if depth > 0 {
+			return nil, fmt.Errorf("invalid route template: %s: missing }", template)
+		}
+		name, pattern, ok := strings.Cut(template[i+1:end-1], ":")
+		if !ok {
+			pattern = "[^/]+"
+		}
+		if !placeholderNameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid route template: %s: invalid placeholder name: %q", template, name)
+		}
+		if _, ok := seen[name]; ok {
+			return nil, fmt.Errorf("invalid route template: %s: duplicate placeholder: %s", template, name)
+		}
+		seen[name] = struct{}{}
+		names = append(names, name)
+		b.WriteString("(?P<" + name + ">" + pattern + ")")
+		i = end - 1
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid route template: %s: %w", template, err)
+	}
+	return &RouteTemplate{
+		Template: template,
+		KeyMode:  mode,
+		names:    names,
+		re:       re,
+	}, nil
+}
+
+// パスがテンプレートにマッチする場合に、プレースホルダの値を名前の順に返す
+func (t *RouteTemplate) Match(path string) ([]string, bool) {
+	m := t.re.FindStringSubmatch(path)
+	if m == nil {
+		return nil, false
+	}
+	values := make([]string, 0, len(t.names))
+	for _, name := range t.names {
+		values = append(values, m[t.re.SubexpIndex(name)])
+	}
+	return values, true
+}
+
+// マッチしたパスのキーを返す
+// RouteKeyTemplateの場合は/users/{id}/export、RouteKeyValuesの場合は/users/{id}/export:id=42のようになる
+func (t *RouteTemplate) key(values []string) string {
+	if t.KeyMode != RouteKeyValues || len(values) == 0 {
+		return t.Template
+	}
+	pairs := make([]string, 0, len(values))
+	for i, v := range values {
+		pairs = append(pairs, t.names[i]+"="+v)
+	}
+	return t.Template + ":" + strings.Join(pairs, ",")
+}
@@ -0,0 +1,61 @@
+package rlutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteTemplate(t *testing.T) {
+	tests := []struct {
+		template   string
+		mode       RouteKeyMode
+		path       string
+		wantValues []string
+		wantMatch  bool
+		wantKey    string
+	}{
+		{"/users/{id}/export", RouteKeyTemplate, "/users/42/export", []string{"42"}, true, "/users/{id}/export"},
+		{"/users/{id}/export", RouteKeyValues, "/users/42/export", []string{"42"}, true, "/users/{id}/export:id=42"},
+		{"/users/{id}/export", RouteKeyValues, "/users/42/7/export", nil, false, ""},
+		{"/users/{id}/export", RouteKeyValues, "/users//export", nil, false, ""},
+		{"/users/{id}/export", RouteKeyValues, "/users/42/export/csv", nil, false, ""},
+		{"/users/{id:[0-9]+}", RouteKeyValues, "/users/42", []string{"42"}, true, "/users/{id:[0-9]+}:id=42"},
+		{"/users/{id:[0-9]+}", RouteKeyValues, "/users/me", nil, false, ""},
+		{"/orgs/{org}/repos/{repo}", RouteKeyValues, "/orgs/a/repos/b", []string{"a", "b"}, true, "/orgs/{org}/repos/{repo}:org=a,repo=b"},
+		{"/files/{path:.+}", RouteKeyTemplate, "/files/a/b.txt", []string{"a/b.txt"}, true, "/files/{path:.+}"},
+		{"/codes/{code:[A-Z]{2}}", RouteKeyValues, "/codes/JP", []string{"JP"}, true, "/codes/{code:[A-Z]{2}}:code=JP"},
+		{"/export.{fmt:csv|json}", RouteKeyValues, "/export.json", []string{"json"}, true, "/export.{fmt:csv|json}:fmt=json"},
+		{"/export.{fmt:csv|json}", RouteKeyValues, "/exportxjson", nil, false, ""},
+		{"/health", RouteKeyValues, "/health", []string{}, true, "/health"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template+" "+tt.path, func(t *testing.T) {
+			rt, err := ParseRouteTemplate(tt.template, tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values, ok := rt.Match(tt.path)
+			assert.Equal(t, tt.wantMatch, ok)
+			if !ok {
+				return
+			}
+			assert.Equal(t, tt.wantValues, values)
+			assert.Equal(t, tt.wantKey, rt.key(values))
+		})
+	}
+
+	for _, template := range []string{
+		"/users/{id",
+		"/users/id}",
+		"/users/{}",
+		"/users/{1id}",
+		"/users/{id}/{id}",
+		"/users/{id:[0-9}",
+	} {
+		_, err := ParseRouteTemplate(template, RouteKeyTemplate)
+		assert.Error(t, err, template)
+	}
+	_, err := ParseRouteTemplate("/users/{id}", RouteKeyMode(99))
+	assert.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package rlutils
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Settings は実行中に変更できるリミッターの設定
+type Settings struct {
+	ReqLimit           int           `mapstructure:"req_limit"`
+	WindowLen          time.Duration `mapstructure:"window_len"`
+	TargetExtensions   []string      `mapstructure:"target_extensions"`
+	TargetMethods      []string      `mapstructure:"target_methods"`
+	IgnorePathContains []string      `mapstructure:"ignore_path_contains"`
+	IgnorePathPrefixes []string      `mapstructure:"ignore_path_prefixes"`
+	IgnorePathSuffixes []string      `mapstructure:"ignore_path_suffixes"`
+}
+
+func (s Settings) validate() error {
+	if s.ReqLimit < 0 {
+		return errors.New("req_limit must not be negative")
+	}
+	if s.WindowLen <= 0 {
+		return errors.New("window_len must be positive")
+	}
+	return nil
+}
+
+// 判定用に変換したSettings
+type settings struct {
+	Settings
+	targetExtensions map[string]struct{}
+	targetMethods    map[string]struct{}
+}
+
+func newSettings(s Settings) *settings {
+	return &settings{
+		Settings:         s,
+		targetExtensions: extensionSet(s.TargetExtensions),
+		targetMethods:    methodSet(s.TargetMethods),
+	}
+}
+
+func extensionSet(targetExtensions []string) map[string]struct{} {
+	m := make(map[string]struct{}, len(targetExtensions))
+	for _, ext := range targetExtensions {
+		if len(ext) > 0 && ext[0] != '.' {
+			ext = "." + ext
+		}
+		m[strings.ToLower(ext)] = struct{}{}
+	}
+	return m
+}
+
+func methodSet(targetMethods []string) map[string]struct{} {
+	m := make(map[string]struct{}, len(targetMethods))
+	for _, method := range targetMethods {
+		m[strings.ToLower(method)] = struct{}{}
+	}
+	return m
+}
+
+func setKeys(m map[string]struct{}) []string {
+	if m == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (s *settings) isTargetExtensions(r *http.Request) bool {
+	if len(s.targetExtensions) == 0 {
+		return true
+	}
+	extension := strings.ToLower(filepath.Ext(r.URL.Path))
+	_, ok := s.targetExtensions[extension]
+	return ok
+}
+
+func (s *settings) isTargetMethod(r *http.Request) bool {
+	if len(s.targetMethods) == 0 {
+		return true
+	}
+	_, ok := s.targetMethods[strings.ToLower(r.Method)]
+	return ok
+}
+
+func (s *settings) isTargetPath(r *http.Request) bool {
+	for _, ignore := range []struct {
+		path []string
+		f    func(string, string) bool
+	}{
+		{s.IgnorePathPrefixes, strings.HasPrefix},
+		{s.IgnorePathSuffixes, strings.HasSuffix},
+		{s.IgnorePathContains, strings.Contains},
+	} {
+		if len(ignore.path) > 0 {
+			for _, ipath := range ignore.path {
+				if ignore.f(r.URL.Path, ipath) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// 現在の設定を返す
+func (l *BaseLimiter) Settings() Settings {
+	return l.settings.Load().Settings
+}
+
+// 設定を差し替える
+// 差し替えは不可分に行われ、処理中のリクエストは差し替え前か後のどちらかの設定で判定される
+// WindowLenを長くする場合は、カウンタが十分な期間の値を保持していることを確認すること
+func (l *BaseLimiter) UpdateSettings(s Settings) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	l.settings.Store(newSettings(s))
+	return nil
+}
@@ -0,0 +1,35 @@
+package rlutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaseLimiter_UpdateSettings(t *testing.T) {
+	limiter := NewHostLimiter(5, time.Minute, nil, TargetMethods([]string{"GET"}))
+	assert.NoError(t, limiter.Err())
+	assert.Equal(t, Settings{ReqLimit: 5, WindowLen: time.Minute, TargetMethods: []string{"get"}}, limiter.Settings())
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/file.txt", nil)
+	rule, err := limiter.Rule(req)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, rule.ReqLimit)
+
+	assert.NoError(t, limiter.UpdateSettings(Settings{
+		ReqLimit:         10,
+		WindowLen:        time.Hour,
+		TargetExtensions: []string{"txt"},
+	}))
+	rule, err = limiter.Rule(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, rule.ReqLimit)
+	assert.Equal(t, time.Hour, rule.WindowLen)
+
+	assert.Error(t, limiter.UpdateSettings(Settings{ReqLimit: -1, WindowLen: time.Hour}))
+	assert.Error(t, limiter.UpdateSettings(Settings{ReqLimit: 1}))
+	assert.Equal(t, 10, limiter.Settings().ReqLimit)
+}
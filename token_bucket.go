@@ -0,0 +1,149 @@
+package rlutils
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/2manymws/rl"
+	"github.com/jellydator/ttlcache/v3"
+)
+
+// TokenBucketCounter はトークンバケットでリクエストを制限するカウンタ
+// トークンはルールの上限をウィンドウの長さで割った速さで補充され、バケットの容量がバーストの上限になる
+// 固定ウィンドウと違い、ウィンドウの境界で上限の2倍のリクエストを受け付けることがない
+//
+// rlはウィンドウごとのリクエスト数として扱うため、Getは容量から残りのトークン数を引いた値を返し、
+// 直前のウィンドウに対しては0を返す
+type TokenBucketCounter struct {
+	burst int
+	cache *ttlcache.Cache[string, *tokenBucket]
+	stop  func()
+	now   func() time.Time
+}
+
+var _ ruleCounter = (*TokenBucketCounter)(nil)
+
+type tokenBucket struct {
+	mu        sync.Mutex
+	rate      float64 // 1秒あたりに補充するトークン数
+	burst     int
+	windowLen time.Duration
+	tokens    float64
+	last      time.Time
+}
+
+// トークンバケットのカウンタを作成する
+// burstはバケットの容量で、0の場合はルールの上限と同じ容量になる
+// 満タンになったバケットは初期状態と同じなので、補充にかかる時間が過ぎると削除する
+func NewTokenBucketCounter(burst int) *TokenBucketCounter {
+	cache := ttlcache.New[string, *tokenBucket]()
+	go cache.Start()
+	return &TokenBucketCounter{
+		burst: burst,
+		cache: cache,
+		stop:  sync.OnceFunc(cache.Stop),
+		now:   time.Now,
+	}
+}
+
+// 期限切れのキーを削除するgoroutineを止める
+func (c *TokenBucketCounter) Stop() {
+	c.stop()
+}
+
+// リミッターのカウンタにトークンバケットを使う
+// 各ルールの上限とウィンドウから補充の速さを求め、burstをバケットの容量にする
+// burstが0の場合はルールの上限と同じ容量になり、X-RateLimit-Limitなどの上限には容量が使われる
+func TokenBucket(burst int) Option {
+	return func(args *Options) {
+		if burst < 0 {
+			args.errs = append(args.errs, fmt.Errorf("invalid burst: %d", burst))
+			return
+		}
+		lazyCounter(func() rl.Counter { return NewTokenBucketCounter(burst) })(args)
+	}
+}
+
+// キーのバケットに補充の速さと容量を設定し、rlに渡す上限として容量を返す
+func (c *TokenBucketCounter) configure(key string, reqLimit int, windowLen time.Duration) int {
+	burst := c.burst
+	if burst == 0 {
+		burst = reqLimit
+	}
+	rate := float64(reqLimit) / windowLen.Seconds()
+	ttl := time.Duration(float64(burst)/rate*float64(time.Second)) + windowLen
+	item, loaded := c.cache.GetOrSet(key, &tokenBucket{
+		rate:      rate,
+		burst:     burst,
+		windowLen: windowLen,
+		tokens:    float64(burst),
+		last:      c.now(),
+	}, ttlcache.WithTTL[string, *tokenBucket](ttl))
+	if loaded {
+		// UpdateSettingsで上限やウィンドウが変わった場合に追従する
+		b := item.Value()
+		b.mu.Lock()
+		if b.rate != rate || b.burst != burst || b.windowLen != windowLen {
+			b.refill(c.now())
+			b.rate, b.burst, b.windowLen = rate, burst, windowLen
+			b.tokens = math.Min(b.tokens, float64(burst))
+		}
+		b.mu.Unlock()
+	}
+	return burst
+}
+
+func (c *TokenBucketCounter) Get(key string, window time.Time) (int, error) { //nostyle:getters
+	item := c.cache.Get(key)
+	if item == nil {
+		return 0, nil
+	}
+	b := item.Value()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := c.now()
+	if isPreviousWindow(window, now, b.windowLen) {
+		return 0, nil
+	}
+	b.refill(now)
+	return max(b.burst-int(math.Floor(b.tokens)), 0), nil
+}
+
+func (c *TokenBucketCounter) Increment(key string, _ time.Time) error {
+	item := c.cache.Get(key)
+	if item == nil {
+		return nil
+	}
+	b := item.Value()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(c.now())
+	// 他のリミッターで制限された場合も呼ばれるため、トークンを負にはしない
+	b.tokens = math.Max(b.tokens-1, 0)
+	return nil
+}
+
+// 次のトークンが補充されるまでの秒数を返す
+func (c *TokenBucketCounter) retryAfter(key string) (int, bool) {
+	item := c.cache.Get(key)
+	if item == nil {
+		return 0, false
+	}
+	b := item.Value()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(c.now())
+	if b.tokens >= 1 {
+		return 1, true
+	}
+	return max(int(math.Ceil((1-b.tokens)/b.rate)), 1), true
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.tokens+elapsed.Seconds()*b.rate, float64(b.burst))
+	}
+	b.last = now
+}
@@ -0,0 +1,102 @@
+package rlutils
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/2manymws/rl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketCounter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 9, 0, time.UTC)
+	c := NewTokenBucketCounter(0)
+	c.now = func() time.Time { return now }
+	window := 10 * time.Second
+
+	assert.Equal(t, 10, c.configure("key", 10, window))
+	count, err := c.Get("key", now.Truncate(window))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, c.Increment("key", now.Truncate(window)))
+	}
+	count, _ = c.Get("key", now.Truncate(window))
+	assert.Equal(t, 10, count)
+
+	// ウィンドウが切り替わってもトークンは補充されていない
+	now = now.Add(1500 * time.Millisecond)
+	count, _ = c.Get("key", now.Truncate(window))
+	assert.Equal(t, 9, count)
+	count, _ = c.Get("key", now.Truncate(window).Add(-window))
+	assert.Equal(t, 0, count)
+
+	// 容量を超えて補充しない
+	now = now.Add(time.Hour)
+	count, _ = c.Get("key", now.Truncate(window))
+	assert.Equal(t, 0, count)
+
+	// 他のリミッターで制限された場合でもトークンは負にならない
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, c.Increment("key", now.Truncate(window)))
+	}
+	count, _ = c.Get("key", now.Truncate(window))
+	assert.Equal(t, 10, count)
+	now = now.Add(time.Second)
+	count, _ = c.Get("key", now.Truncate(window))
+	assert.Equal(t, 9, count)
+
+	// 未知のキー
+	count, _ = c.Get("unknown", now.Truncate(window))
+	assert.Equal(t, 0, count)
+}
+
+func TestTokenBucketCounter_Burst(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewTokenBucketCounter(3)
+	c.now = func() time.Time { return now }
+
+	assert.Equal(t, 3, c.configure("key", 60, time.Minute))
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, c.Increment("key", now.Truncate(time.Minute)))
+	}
+	count, _ := c.Get("key", now.Truncate(time.Minute))
+	assert.Equal(t, 3, count)
+	retryAfter, ok := c.retryAfter("key")
+	assert.True(t, ok)
+	assert.Equal(t, 1, retryAfter)
+
+	// 上限の変更に追従する
+	assert.Equal(t, 3, c.configure("key", 6, time.Minute))
+	retryAfter, _ = c.retryAfter("key")
+	assert.Equal(t, 10, retryAfter)
+}
+
+func TestTokenBucket_Limiter(t *testing.T) {
+	limiter := NewHostLimiter(2, time.Hour, func(_ *rl.Context, _ string) http.HandlerFunc {
+		return func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}, TokenBucket(1), ResponseHeaders(HeaderModeXRateLimit))
+	assert.NoError(t, limiter.Err())
+
+	h := New(limiter)(okHandler())
+	rec := serveHeaderTest(h)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("X-RateLimit-Remaining"))
+
+	rec = serveHeaderTest(h)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	// 1時間に2トークンなので、次のトークンは30分後に補充される
+	retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	assert.NoError(t, err)
+	assert.InDelta(t, 1800, retryAfter, 1)
+}
+
+func TestTokenBucket_Invalid(t *testing.T) {
+	assert.Error(t, NewHostLimiter(2, time.Hour, nil, TokenBucket(-1)).Err())
+}
@@ -1,6 +1,7 @@
 package rlutils
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -10,11 +11,14 @@ import (
 
 type UserAgentLimiter struct {
 	userAgents []string
-	BaseLimiter
+	Limiter
 }
 
 // ユーザーエージェントごとにリクエスト数を制限する
 // 制限単位はユーザーエージェント
+// UserAgentRulesでルールを指定した場合は、userAgentsより先にルールを判定する
+// VerifyCrawlersを指定した場合は、クローラーのなりすましをさらに先に判定する
+// オプションが不正な場合はErrでエラーを返し、Ruleもエラーを返す
 func NewUserAgentLimiter(
 	userAgents []string,
 	reqLimit int,
@@ -22,37 +26,59 @@ func NewUserAgentLimiter(
 	onRequestLimit func(*rl.Context, string) http.HandlerFunc,
 	setter ...Option,
 ) *UserAgentLimiter {
-	return &UserAgentLimiter{
+	l := &UserAgentLimiter{
 		userAgents: userAgents,
-		BaseLimiter: NewBaseLimiter(
-			reqLimit,
-			windowLen,
-			onRequestLimit,
-			setter...,
-		),
 	}
+	base, err := NewLimiter(
+		"user_agent_limiter",
+		nil,
+		l.userAgentKey,
+		reqLimit,
+		windowLen,
+		onRequestLimit,
+		append([]Option{acceptOptions("UserAgentRules", "UseBotCatalog", "VerifyCrawlers")}, setter...)...,
+	)
+	if err != nil {
+		l.Limiter = failedLimiter("user_agent_limiter", err)
+		return l
+	}
+	l.Limiter = *base
+	for _, rule := range l.userAgentRules {
+		if rule.Match == UserAgentFamily && !l.botCatalog.HasFamily(BotFamily(rule.Pattern)) {
+			l.Stop()
+			l.Limiter = failedLimiter("user_agent_limiter", fmt.Errorf("user agent rule %s: unknown bot family: %s", rule.Name, rule.Pattern))
+			return l
+		}
+	}
+	return l
 }
 
-func (l *UserAgentLimiter) Name() string {
-	return "user_agent_limiter"
-}
-
-func (l *UserAgentLimiter) Rule(r *http.Request) (*rl.Rule, error) {
-	if !l.IsTargetRequest(r) {
-		return &rl.Rule{ReqLimit: -1}, nil
-	}
+func (l *UserAgentLimiter) userAgentKey(r *http.Request) (string, bool) {
 	for _, ua := range l.userAgents {
 		if strings.Contains(r.UserAgent(), ua) {
-			return &rl.Rule{
-				Key:       ua,
-				ReqLimit:  l.reqLimit,
-				WindowLen: l.windowLen,
-			}, nil
+			return ua, true
 		}
 	}
-	return &rl.Rule{ReqLimit: -1}, nil
+	return "", false
 }
 
-func (l *UserAgentLimiter) OnRequestLimit(r *rl.Context) http.HandlerFunc {
-	return l.onRequestLimit(r, l.Name())
+// UserAgentRulesにマッチした場合はルールの上限とウィンドウを使う
+// VerifyCrawlersでクローラーのなりすましと判定された場合はimpostorのルールを使う
+func (l *UserAgentLimiter) Rule(r *http.Request) (*rl.Rule, error) {
+	if len(l.userAgentRules) == 0 && l.impostorRule == nil {
+		return l.Limiter.Rule(r)
+	}
+	s := l.settings.Load()
+	if !l.isTargetRequest(s, r) {
+		return &rl.Rule{ReqLimit: -1}, nil
+	}
+	rule, ok := l.matchUserAgentRule(r)
+	if !ok {
+		return l.Limiter.Rule(r)
+	}
+	key := rule.Name
+	if rule.KeyByClientIP {
+		key += "+" + l.remoteAddrKey(r)
+	}
+	return l.newRule(l.namespacedKey(key), rule.ReqLimit, rule.WindowLen), nil
 }
@@ -39,6 +39,7 @@ func TestUserAgentLimiter(t *testing.T) {
 		nil,
 		nil,
 	)
+	assert.NoError(t, limiter.Err())
 	limiter.Counter = mockCounter
 
 	// Create a request with matching User-Agent
@@ -52,7 +53,7 @@ func TestUserAgentLimiter(t *testing.T) {
 	if rule != nil {
 		assert.Equal(t, reqLimit, rule.ReqLimit)
 		assert.Equal(t, windowLen, rule.WindowLen)
-		assert.Equal(t, "TestBot", rule.Key)
+		assert.Equal(t, "user_agent_limiter:TestBot", rule.Key)
 	}
 
 	// Create a request with a non-matching User-Agent
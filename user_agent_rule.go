@@ -0,0 +1,157 @@
+package rlutils
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// UserAgentMatchType はUserAgentRuleのパターンの種類
+type UserAgentMatchType string
+
+const (
+	// パターンを含めばマッチする
+	UserAgentContains UserAgentMatchType = "contains"
+	// 大文字と小文字を区別せずにパターンを含めばマッチする
+	UserAgentContainsFold UserAgentMatchType = "contains_fold"
+	// 正規表現にマッチすればマッチする
+	UserAgentRegexp UserAgentMatchType = "regexp"
+	// ボットカタログでパターンの分類に判別されればマッチする
+	UserAgentFamily UserAgentMatchType = "family"
+)
+
+// UserAgentRule はUserAgentLimiterでユーザーエージェントごとに上限を変えるためのルール
+// MatchがUserAgentFamilyの場合は、Patternにai_scraperのようなボットの分類を指定する
+// KeyByClientIPを指定すると、ルールとクライアントのIPの組で数える
+// Nameを省略した場合はPatternが使われ、キーにはNameが含まれる
+type UserAgentRule struct {
+	Name          string             `yaml:"name"`
+	Match         UserAgentMatchType `yaml:"match"`
+	Pattern       string             `yaml:"pattern"`
+	ReqLimit      int                `yaml:"req_limit"`
+	WindowLen     time.Duration      `yaml:"window_len"`
+	KeyByClientIP bool               `yaml:"key_by_client_ip"`
+	re            *regexp.Regexp
+	lower         string
+}
+
+func (u *UserAgentRule) compile() error {
+	if u.Name == "" {
+		u.Name = u.Pattern
+	}
+	if u.Pattern == "" {
+		return fmt.Errorf("user agent rule %s: pattern is required", u.Name)
+	}
+	if err := (Settings{ReqLimit: u.ReqLimit, WindowLen: u.WindowLen}).validate(); err != nil {
+		return fmt.Errorf("user agent rule %s: %w", u.Name, err)
+	}
+	switch u.Match {
+	case UserAgentContains, UserAgentFamily:
+	case UserAgentContainsFold:
+		u.lower = strings.ToLower(u.Pattern)
+	case UserAgentRegexp:
+		re, err := regexp.Compile(u.Pattern)
+		if err != nil {
+			return fmt.Errorf("user agent rule %s: %w", u.Name, err)
+		}
+		u.re = re
+	default:
+		return fmt.Errorf("user agent rule %s: invalid match type: %q", u.Name, u.Match)
+	}
+	return nil
+}
+
+func (u *UserAgentRule) match(ua string, bot func() *BotSignature) bool {
+	switch u.Match {
+	case UserAgentFamily:
+		s := bot()
+		return s != nil && string(s.Family) == u.Pattern
+	case UserAgentContains:
+		return strings.Contains(ua, u.Pattern)
+	case UserAgentContainsFold:
+		return strings.Contains(strings.ToLower(ua), u.lower)
+	case UserAgentRegexp:
+		return u.re.MatchString(ua)
+	}
+	return false
+}
+
+// UserAgentLimiterでユーザーエージェントごとに上限を変えるルールを指定する
+// ルールは指定した順に判定し、最初にマッチしたルールの上限とウィンドウを使う
+// どのルールにもマッチしない場合は、リミッターのユーザーエージェントの指定と上限で判定する
+func UserAgentRules(rules []UserAgentRule) Option {
+	return func(args *Options) {
+		args.limiterOption("UserAgentRules")
+		names := map[string]struct{}{}
+		for _, rule := range rules {
+			rule := rule
+			if err := rule.compile(); err != nil {
+				args.errs = append(args.errs, err)
+				continue
+			}
+			if _, ok := names[rule.Name]; ok {
+				args.errs = append(args.errs, fmt.Errorf("duplicate user agent rule: %s", rule.Name))
+				continue
+			}
+			names[rule.Name] = struct{}{}
+			args.UserAgentRules = append(args.UserAgentRules, &rule)
+			args.ruleWindow(rule.WindowLen)
+		}
+	}
+}
+
+// リクエストにマッチするルールを返す
+// OnRequestLimitの中で、どのルールで制限されたかを調べるために使う
+// VerifyCrawlersでクローラーのなりすましと判定された場合はimpostorのルールを返す
+func (l *UserAgentLimiter) MatchedUserAgentRule(r *http.Request) (UserAgentRule, bool) {
+	rule, ok := l.matchUserAgentRule(r)
+	if !ok {
+		return UserAgentRule{}, false
+	}
+	return *rule, true
+}
+
+func (l *UserAgentLimiter) matchUserAgentRule(r *http.Request) (*UserAgentRule, bool) {
+	ua := r.UserAgent()
+	// カタログによる判別は、クローラーの検証か分類のルールで必要になった時に一度だけ行う
+	var (
+		classified bool
+		signature  *BotSignature
+	)
+	bot := func() *BotSignature {
+		if !classified {
+			signature = l.botCatalog.classify(ua)
+			classified = true
+		}
+		return signature
+	}
+	if l.isImpostor(r, bot) {
+		return l.impostorRule, true
+	}
+	for _, rule := range l.userAgentRules {
+		if rule.match(ua, bot) {
+			return rule, true
+		}
+	}
+	return nil, false
+}
+
+// クローラーを名乗っているのに検証に失敗したかを返す
+// VerifyCrawlersを指定していない場合はカタログで判別しない
+func (l *UserAgentLimiter) isImpostor(r *http.Request, bot func() *BotSignature) bool {
+	if l.crawlerVerifier == nil {
+		return false
+	}
+	s := bot()
+	if s == nil || len(s.VerifyDomains) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(l.remoteAddr(r))
+	if err != nil {
+		return true
+	}
+	return !l.crawlerVerifier.Verify(r.Context(), addr, s.VerifyDomains)
+}
@@ -0,0 +1,135 @@
+package rlutils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserAgentLimiterUserAgentRules(t *testing.T) {
+	rules := []UserAgentRule{
+		{Name: "googlebot", Match: UserAgentContainsFold, Pattern: "googlebot", ReqLimit: 100, WindowLen: time.Minute},
+		{Name: "crawler", Match: UserAgentRegexp, Pattern: `(?i)(crawler|spider)/[0-9.]+`, ReqLimit: 10, WindowLen: time.Second, KeyByClientIP: true},
+		{Match: UserAgentContains, Pattern: "curl/", ReqLimit: 1, WindowLen: time.Hour},
+	}
+	cases := []struct {
+		name              string
+		userAgent         string
+		remoteAddr        string
+		options           []Option
+		expectedRule      string
+		expectedKey       string
+		expectedReqLimit  int
+		expectedWindowLen time.Duration
+	}{
+		{
+			name:              "Case-insensitive rule",
+			userAgent:         "Mozilla/5.0 (compatible; GoogleBot/2.1)",
+			expectedRule:      "googlebot",
+			expectedKey:       "user_agent_limiter:googlebot",
+			expectedReqLimit:  100,
+			expectedWindowLen: time.Minute,
+		},
+		{
+			name:              "Regexp rule keyed by client IP",
+			userAgent:         "ExampleSpider/1.2",
+			remoteAddr:        "192.0.2.1:1234",
+			expectedRule:      "crawler",
+			expectedKey:       "user_agent_limiter:crawler+192.0.2.1",
+			expectedReqLimit:  10,
+			expectedWindowLen: time.Second,
+		},
+		{
+			name:              "Client IP is aggregated by prefix",
+			userAgent:         "ExampleSpider/1.2",
+			remoteAddr:        "192.0.2.1:1234",
+			options:           []Option{IPPrefixLength(24, 0)},
+			expectedRule:      "crawler",
+			expectedKey:       "user_agent_limiter:crawler+192.0.2.0/24",
+			expectedReqLimit:  10,
+			expectedWindowLen: time.Second,
+		},
+		{
+			name:              "Case-sensitive rule named by pattern",
+			userAgent:         "curl/8.0.1",
+			expectedRule:      "curl/",
+			expectedKey:       "user_agent_limiter:curl/",
+			expectedReqLimit:  1,
+			expectedWindowLen: time.Hour,
+		},
+		{
+			name:              "Case-sensitive rule does not match",
+			userAgent:         "Curl/8.0.1",
+			expectedReqLimit:  -1,
+			expectedWindowLen: 0,
+		},
+		{
+			name:              "Falls back to user agents",
+			userAgent:         "TestBot 1.0",
+			expectedKey:       "user_agent_limiter:TestBot",
+			expectedReqLimit:  5,
+			expectedWindowLen: 2 * time.Minute,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter := NewUserAgentLimiter([]string{"TestBot"}, 5, 2*time.Minute, nil, append([]Option{UserAgentRules(rules)}, tc.options...)...)
+			if err := limiter.Err(); err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("User-Agent", tc.userAgent)
+			if tc.remoteAddr != "" {
+				req.RemoteAddr = tc.remoteAddr
+			}
+			rule, err := limiter.Rule(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedKey, rule.Key)
+			assert.Equal(t, tc.expectedReqLimit, rule.ReqLimit)
+			assert.Equal(t, tc.expectedWindowLen, rule.WindowLen)
+
+			matched, ok := limiter.MatchedUserAgentRule(req)
+			assert.Equal(t, tc.expectedRule != "", ok)
+			assert.Equal(t, tc.expectedRule, matched.Name)
+		})
+	}
+}
+
+func TestUserAgentRulesInvalid(t *testing.T) {
+	for _, rules := range [][]UserAgentRule{
+		{{Match: UserAgentContains, ReqLimit: 1, WindowLen: time.Minute}},
+		{{Match: "prefix", Pattern: "bot", ReqLimit: 1, WindowLen: time.Minute}},
+		{{Match: UserAgentRegexp, Pattern: "(", ReqLimit: 1, WindowLen: time.Minute}},
+		{{Match: UserAgentContains, Pattern: "bot", ReqLimit: -1, WindowLen: time.Minute}},
+		{{Match: UserAgentContains, Pattern: "bot", ReqLimit: 1}},
+		{
+			{Match: UserAgentContains, Pattern: "bot", ReqLimit: 1, WindowLen: time.Minute},
+			{Match: UserAgentContainsFold, Pattern: "bot", ReqLimit: 1, WindowLen: time.Minute},
+		},
+	} {
+		assert.Error(t, NewUserAgentLimiter(nil, 5, time.Minute, nil, UserAgentRules(rules)).Err())
+	}
+}
+
+func TestUserAgentRulesDoNotClassifyWithoutFamilyRule(t *testing.T) {
+	rules := []UserAgentRule{
+		{Name: "googlebot", Match: UserAgentContainsFold, Pattern: "googlebot", ReqLimit: 100, WindowLen: time.Minute},
+	}
+	limiter := NewUserAgentLimiter(nil, 5, time.Minute, nil, UserAgentRules(rules))
+	if err := limiter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	defer limiter.Stop()
+	// VerifyCrawlersも分類のルールもない場合は、カタログの正規表現を評価しない
+	limiter.botCatalog = nil
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)")
+	rule, ok := limiter.MatchedUserAgentRule(req)
+	assert.True(t, ok)
+	assert.Equal(t, "googlebot", rule.Name)
+}